@@ -1,7 +1,7 @@
 #!/bin/bash
 
 main() {
-	local dirs="broker consumer publisher"
+	local dirs="broker consumer publisher udfs"
 	local dir
 
 	for dir in ${dirs}; do
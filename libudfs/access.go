@@ -0,0 +1,143 @@
+package udfs
+
+import (
+	"sync"
+	"time"
+
+	. "asdf"
+)
+
+// access of pull, coalesced @memory and flushed by Conf.AccessFlush
+//
+// 1. atime of entry, for eviction(see evict.go)
+// 2. touch-on-pull(Conf.TouchOnPull or namespace's), the pulled file is touched @group
+//    at most once per Conf.TouchInterval, NOT touch storm by hot files
+
+const (
+	deftAccessFlush   = 60   // second
+	deftTouchInterval = 3600 // second
+)
+
+type accessLog struct {
+	lock  sync.Mutex
+	times map[string]Time32 // bkdr(4) + digest ==> the last access
+}
+
+var accesses = &accessLog{
+	times: map[string]Time32{},
+}
+
+// namespace's, or Conf.TouchOnPull if NOT set
+func (me *Conf) touchOnPull(ns byte) bool {
+	if v := me.namespace(ns); nil != v && nil != v.TouchOnPull {
+		return *v.TouchOnPull
+	}
+
+	return me.TouchOnPull
+}
+
+// any namespace touch on pull
+func (me *Conf) touchOnPullAny() bool {
+	if me.TouchOnPull {
+		return true
+	}
+
+	for _, v := range me.Namespaces {
+		if nil != v.TouchOnPull && *v.TouchOnPull {
+			return true
+		}
+	}
+
+	return false
+}
+
+func accessTracked() bool {
+	return conf.Evict.enabled() || conf.touchOnPullAny()
+}
+
+func (me *accessLog) add(bkdr Bkdr, digest []byte) {
+	me.lock.Lock()
+	me.times[string(dbTombKey(bkdr, digest))] = NowTime32()
+	me.lock.Unlock()
+}
+
+func (me *accessLog) take() map[string]Time32 {
+	me.lock.Lock()
+	times := me.times
+	me.times = map[string]Time32{}
+	me.lock.Unlock()
+
+	return times
+}
+
+// update atime of entries, and touch the pulled
+func (me *accessLog) flush() {
+	times := me.take()
+	if 0 == len(times) {
+		return
+	}
+
+	keys := make([]string, 0, len(times))
+	for k := range times {
+		keys = append(keys, k)
+	}
+
+	touches := []*DbEntry{}
+
+	for i := 0; i < len(keys); i += dbGcBatch {
+		batch := keys[i:]
+		if len(batch) > dbGcBatch {
+			batch = batch[:dbGcBatch]
+		}
+
+		err := db.Update(func(tx MetaTx) error {
+			for _, k := range batch {
+				bkdr := Bkdr(Ntohl([]byte(k)))
+				digest := []byte(k[SizeofInt32:])
+				atime := times[k]
+
+				entry := &DbEntry{}
+				if v := tx.Get(dbBucket(bkdr), digest); nil == v || nil != entry.FromBinary(v) {
+					// deleted
+					continue
+				}
+
+				if conf.touchOnPull(entry.ns) && atime >= entry.time+conf.TouchInterval {
+					touches = append(touches, entry)
+				}
+
+				if entry.atime >= atime {
+					continue
+				}
+				entry.atime = atime
+
+				if err := dbPutTx(tx, entry); nil != err {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if nil != err {
+			Log.Error("access time flush error:%v", err)
+		}
+	}
+
+	for _, entry := range touches {
+		if err := ep.touchPulled(entry.bkdr, entry.digest[:], entry.atime, entry.ns); nil != err {
+			Log.Error("touch on pull %s error:%v", entry.String(), err)
+		}
+	}
+}
+
+// broker, background
+func accessRun() {
+	chFlush := time.Tick(time.Duration(conf.AccessFlush) * time.Second)
+
+	for {
+		select {
+		case <-chFlush:
+			accesses.flush()
+		}
+	}
+}
@@ -11,6 +11,26 @@ func BrokerMain() {
 
 	go ep.listen()
 
+	if diskMigrating {
+		go diskMigrate()
+	}
+
+	if v, ok := blobStore.(*VolumeBlobStore); ok {
+		go v.compact()
+	}
+
+	if nil != xrepl {
+		go xrepl.run()
+	}
+
+	if accessTracked() {
+		go accessRun()
+	}
+
+	if conf.Evict.enabled() {
+		go evict()
+	}
+
 	ep.gc()
 }
 
@@ -22,14 +42,14 @@ func InitConsumer() {
 
 // consumer api
 func ConsumerPull(bkdr Bkdr, digest []byte) (FileName, error) {
-	err := ep.leader(bkdr).pull(bkdr, digest)
+	// consumer always pull from local broker
+	// local broker reply the filename
+	filename, err := ep.leader(bkdr).pull(bkdr, digest)
 	if nil != err {
 		// NOT pull from follers
 		// do nothing, just error
 		return Empty, err
 	} else {
-		filename := dbConf.File(bkdr, digest).name
-
 		return filename, nil
 	}
 }
@@ -39,35 +59,150 @@ func ConsumerPull(bkdr Bkdr, digest []byte) (FileName, error) {
 func InitPublisher() {
 	initRole(rolePublisher)
 
-	go ep.gc()
+	go ep.prune()
+}
+
+// publisher push option
+type PushOption struct {
+	Namespace   string            // empty is default namespace
+	ContentType string            // maybe empty
+	Meta        map[string]string // custom attrs, maybe nil
+
+	// expiry, at most one, both 0 is the live of namespace
+	TTL    Time32 // second, from the last push, at most Conf.MaxTTL
+	Expire Time32 // absolute unix time
 }
 
 // publisher api
 func PublisherPush(bkdr Bkdr, digest, content []byte) error {
-	var err error
+	return PublisherPushOpt(bkdr, digest, content, nil)
+}
+
+// publisher api
+func PublisherPushOpt(bkdr Bkdr, digest, content []byte, opt *PushOption) error {
+	if nil == opt {
+		opt = &PushOption{}
+	}
+
+	ns, err := conf.findNamespace(opt.Namespace)
+	if nil != err {
+		return err
+	}
+
+	attr := &FileAttr{
+		ns:     ns,
+		size:   uint64(len(content)),
+		ctype:  opt.ContentType,
+		meta:   opt.Meta,
+		ttl:    opt.TTL,
+		expire: opt.Expire,
+	}
+	if err = attr.check(); nil != err {
+		return err
+	}
 
 	leader := ep.leader(bkdr)
 
-	if dbExist(bkdr, digest) {
+	// push again with new expiry, NOT just touch
+	if dbExist(bkdr, digest) && 0 == attr.ttl && 0 == attr.expire {
 		// 1. try push to leader
 		// 2. if error, push to followers
-		err = leader.touch(bkdr, digest)
+		err = leader.touch(bkdr, digest, 0)
 		if nil != err {
-			err = ep.touchFollowers(bkdr, digest)
+			err = ep.touchFollowers(bkdr, digest, 0, ns)
 		}
 	} else {
 		// 1. try push to leader
 		// 2. if error, push to followers
-		err = leader.push(bkdr, 0, digest, content)
+		err = leader.push(bkdr, 0, digest, content, attr)
 		if nil != err {
-			err = ep.pushFollowers(bkdr, 0, digest, content)
+			err = ep.pushFollowers(bkdr, 0, digest, content, attr)
 		}
 	}
 	if nil != err {
 		return err
 	}
 
-	_, err = dbAdd(bkdr, digest, 0)
+	_, err = dbAdd(bkdr, digest, 0, ns)
+
+	return err
+}
+
+// publisher del option
+type DeleteOption struct {
+	Force bool // NOT trash, the file deleted now, NOT undelete
+}
+
+// publisher api
+// del the file @all replicas, return count of removed copies
+// the tombstone keep it from the older push/repair
+func PublisherDelete(bkdr Bkdr, digest []byte) (int, error) {
+	return PublisherDeleteOpt(bkdr, digest, nil)
+}
+
+// publisher api
+func PublisherDeleteOpt(bkdr Bkdr, digest []byte, opt *DeleteOption) (int, error) {
+	if nil == opt {
+		opt = &DeleteOption{}
+	}
+
+	bkdr = newbkdr(bkdr, digest)
+
+	// 1. try del @leader, it del @followers
+	// 2. if error, del @followers
+	count, err := ep.leader(bkdr).del(bkdr, digest, 0, opt.Force)
+	if nil != err {
+		// ns @publisher's cache, maybe default
+		entry, _ := dbEntry(bkdr, digest, nsDefault)
+
+		count, err = ep.delFollowers(bkdr, digest, 0, entry.ns, opt.Force)
+	}
+
+	// publisher's cache
+	dbDel(bkdr, digest)
+
+	return count, err
+}
+
+// publisher api
+// restore the deleted file from trash, in Conf.TrashGrace
+func PublisherUndelete(bkdr Bkdr, digest []byte) error {
+	bkdr = newbkdr(bkdr, digest)
+
+	// 1. try undel @leader, it undel @followers
+	// 2. if error, undel @followers
+	err := ep.leader(bkdr).undel(bkdr, digest)
+	if nil != err {
+		// ns is unknow, the entry is NOT @publisher's cache
+		err = ep.undelFollowers(bkdr, digest, nsDefault)
+	}
+
+	return err
+}
+
+// publisher api
+// pinned file is NOT gc, whatever live/ttl
+func PublisherPin(bkdr Bkdr, digest []byte) error {
+	return publisherPin(bkdr, digest, true)
+}
+
+// publisher api
+func PublisherUnpin(bkdr Bkdr, digest []byte) error {
+	return publisherPin(bkdr, digest, false)
+}
+
+func publisherPin(bkdr Bkdr, digest []byte, pin bool) error {
+	bkdr = newbkdr(bkdr, digest)
+
+	// 1. try pin @leader, it pin @followers
+	// 2. if error, pin @followers
+	err := ep.leader(bkdr).pin(bkdr, digest, pin)
+	if nil != err {
+		// ns @publisher's cache, maybe default
+		entry, _ := dbEntry(bkdr, digest, nsDefault)
+
+		err = ep.pinFollowers(bkdr, digest, pin, entry.ns)
+	}
 
 	return err
 }
@@ -0,0 +1,316 @@
+package udfs
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"sync"
+
+	. "asdf"
+)
+
+const (
+	deftAuthWindow = 300 // second
+
+	sizeofAuthNonce = 8
+	sizeofAuthMac   = sha256.Size
+	sizeofProtoAuth = SizeofInt32 + sizeofAuthNonce + sizeofAuthMac
+
+	ENV_UDFS_CLIENT = "APT_UDFS_CLIENT"
+	ENV_UDFS_SECRET = "APT_UDFS_SECRET"
+
+	etcdRemoteSecret = "remote/" // ETCD_UDFS_SECRET + etcdRemoteSecret + remote client name
+)
+
+var (
+	ErrAuth   = errors.New("auth failed")
+	ErrDenied = errors.New("permission denied")
+)
+
+// this process's credential
+// nil if auth disabled
+var thisClient *ClientConf
+
+// the nonces seen in the window, a request NOT accepted twice
+var authNonces = &AuthNonces{
+	seen: map[authNonceKey]Time32{},
+}
+
+type authNonceKey struct {
+	client uint32
+	nonce  [sizeofAuthNonce]byte
+}
+
+type AuthNonces struct {
+	lock   sync.Mutex
+	seen   map[authNonceKey]Time32 // nonce ==> request time
+	pruned Time32
+}
+
+// false if the nonce seen
+func (me *AuthNonces) add(client uint32, nonce []byte, time Time32) bool {
+	key := authNonceKey{
+		client: client,
+	}
+	copy(key.nonce[:], nonce)
+
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	// the out of window request is rejected by time, drop its nonce
+	now := NowTime32()
+	if now >= me.pruned+conf.Auth.Window {
+		for k, t := range me.seen {
+			if t+conf.Auth.Window < now {
+				delete(me.seen, k)
+			}
+		}
+		me.pruned = now
+	}
+
+	if _, ok := me.seen[key]; ok {
+		return false
+	}
+	me.seen[key] = time
+
+	return true
+}
+
+// which cmd can the role do
+var rolePerms = [roleEnd][cmdEnd]bool{
+	roleConsumer: {
+		cmdPull: true,
+	},
+	rolePublisher: {
+		cmdPush:  true,
+		cmdTouch: true,
+		cmdDel:   true,
+		cmdPin:   true,
+		cmdUndel: true,
+	},
+	roleBroker: {
+		cmdPush:  true,
+		cmdTouch: true,
+		cmdPull:  true,
+		cmdDel:   true,
+		cmdPin:   true,
+		cmdUndel: true,
+		cmdExist: true,
+		cmdList:  true,
+	},
+}
+
+// client credential
+// load from etcd, with Conf
+// the secret is NOT @Conf(every process read it), see initAuth
+type ClientConf struct {
+	ID    uint32    `json:"id"`
+	Name  string    `json:"name"`
+	Role  string    `json:"role"`
+	Quota QuotaConf `json:"quota"` // just for publisher
+
+	role   Role
+	secret []byte
+}
+
+func (me *ClientConf) can(cmd ProtoCmd) bool {
+	return me.role.IsGood() && cmd.IsGood() && rolePerms[me.role][cmd]
+}
+
+type AuthConf struct {
+	Enable  bool          `json:"enable"`
+	Window  Time32        `json:"window"` // max clock skew of request
+	Clients []*ClientConf `json:"clients"`
+}
+
+func (me *AuthConf) setDefault() {
+	if 0 == me.Window {
+		me.Window = deftAuthWindow
+	}
+}
+
+func (me *AuthConf) check() int {
+	if !me.Enable {
+		return 0
+	}
+
+	for i, client := range me.Clients {
+		client.role = roleFromString(client.Role)
+		if !client.role.IsGood() {
+			Log.Error("auth client:%s bad role:%s", client.Name, client.Role)
+
+			return StdErrError
+		}
+
+		for _, other := range me.Clients[:i] {
+			if other.ID == client.ID || other.Name == client.Name {
+				Log.Error("auth client:%s dup with client:%s", client.Name, other.Name)
+
+				return StdErrError
+			}
+		}
+	}
+
+	return 0
+}
+
+func (me *AuthConf) find(id uint32) *ClientConf {
+	for _, client := range me.Clients {
+		if id == client.ID {
+			return client
+		}
+	}
+
+	return nil
+}
+
+func (me *AuthConf) findByName(name string) *ClientConf {
+	for _, client := range me.Clients {
+		if name == client.Name {
+			return client
+		}
+	}
+
+	return nil
+}
+
+// mac of request binary + trailer's time and nonce
+func authMac(client *ClientConf, bin []byte, trailer []byte) []byte {
+	mac := hmac.New(sha256.New, client.secret)
+	mac.Write(bin)
+	mac.Write(trailer[:SizeofInt32+sizeofAuthNonce])
+
+	return mac.Sum(nil)
+}
+
+// request binary ==> request binary + auth trailer
+//
+// auth trailer: time(uint32) + nonce([sizeofAuthNonce]byte) + mac([sizeofAuthMac]byte)
+// mac = hmac-sha256(secret, request binary + time + nonce)
+func authSign(bin []byte, client *ClientConf) []byte {
+	signed := make([]byte, len(bin)+sizeofProtoAuth)
+	copy(signed, bin)
+
+	trailer := signed[len(bin):]
+	Htonl(trailer, uint32(NowTime32()))
+	if _, err := rand.Read(trailer[SizeofInt32 : SizeofInt32+sizeofAuthNonce]); nil != err {
+		Log.Error("auth nonce error:%v", err)
+	}
+	copy(trailer[SizeofInt32+sizeofAuthNonce:], authMac(client, bin, trailer))
+
+	return signed
+}
+
+// check request's credential and permission
+func authCheck(hdr *ProtoHeader, bin []byte) error {
+	if !conf.Auth.Enable {
+		return nil
+	} else if !hdr.flag.Has(flagAuth) || len(bin) < sizeofProtoHeader+sizeofProtoAuth {
+		Log.Info("auth: %s without credential", hdr.String())
+
+		return ErrAuth
+	}
+
+	client := conf.Auth.find(hdr.client)
+	if nil == client {
+		Log.Info("auth: %s unknow client", hdr.String())
+
+		return ErrAuth
+	}
+
+	body := bin[:len(bin)-sizeofProtoAuth]
+	trailer := bin[len(body):]
+
+	time := Time32(Ntohl(trailer))
+	now := NowTime32()
+	if time > now+conf.Auth.Window || time+conf.Auth.Window < now {
+		Log.Info("auth: %s client:%s time:%v out of window", hdr.String(), client.Name, time.Unix())
+
+		return ErrAuth
+	}
+
+	if !hmac.Equal(trailer[SizeofInt32+sizeofAuthNonce:], authMac(client, body, trailer)) {
+		Log.Info("auth: %s client:%s bad mac", hdr.String(), client.Name)
+
+		return ErrAuth
+	}
+
+	// after the mac, the forged NOT fill the nonces
+	if !authNonces.add(client.ID, trailer[SizeofInt32:SizeofInt32+sizeofAuthNonce], time) {
+		Log.Info("auth: %s client:%s replayed", hdr.String(), client.Name)
+
+		return ErrAuth
+	}
+
+	if !client.can(hdr.cmd) {
+		Log.Info("auth: %s client:%s role:%s denied", hdr.String(), client.Name, client.Role)
+
+		return ErrDenied
+	}
+
+	return nil
+}
+
+// load secret of client from etcd ETCD_UDFS_SECRET + path
+func (me *ClientConf) loadSecret(path string) {
+	secret, err := getEtcd(ETCD_UDFS_SECRET+path, etcdTimeout)
+	if nil != err {
+		panic(StdErrError)
+	} else if 0 == len(secret) {
+		Log.Error("auth client:%s empty secret", me.Name)
+
+		panic(StdErrError)
+	}
+
+	me.secret = secret
+}
+
+// the secrets NOT @Conf
+// broker: all clients(and the remote's) by etcd ETCD_UDFS_SECRET, the etcd ACL just let brokers read it
+// others(and tools): just this client's by ENV_UDFS_SECRET
+func initAuth(role Role) {
+	if !conf.Auth.Enable {
+		return
+	}
+
+	if roleBroker == role {
+		for _, client := range conf.Auth.Clients {
+			client.loadSecret(client.Name)
+		}
+
+		if nil != conf.Remote && nil != conf.Remote.Client {
+			conf.Remote.Client.loadSecret(etcdRemoteSecret + conf.Remote.Client.Name)
+		}
+	}
+
+	initThisClient(role)
+}
+
+func initThisClient(role Role) {
+	name := os.Getenv(ENV_UDFS_CLIENT)
+	if Empty == name && roleBroker == role {
+		name = conf.Nodes[thisNodeID].Name
+	}
+
+	client := conf.Auth.findByName(name)
+	if nil == client {
+		Log.Error("etcd config auth clients not include client:%s", name)
+
+		panic(StdErrError)
+	}
+
+	if roleBroker != role {
+		secret := os.Getenv(ENV_UDFS_SECRET)
+		if Empty == secret {
+			Log.Error("auth client:%s no ENV:%s", name, ENV_UDFS_SECRET)
+
+			panic(StdErrNoEnv)
+		}
+
+		client.secret = []byte(secret)
+	}
+
+	thisClient = client
+}
@@ -0,0 +1,60 @@
+package udfs
+
+import (
+	"testing"
+)
+
+func authTestSign(t *testing.T, client *ClientConf) (*ProtoHeader, []byte) {
+	list := &ProtoList{
+		ProtoHeader: NewProtoHeader(cmdList, flagAuth),
+		name:        listDb,
+	}
+	list.client = client.ID
+
+	bin := make([]byte, list.Size())
+	if err := list.ToBinary(bin); nil != err {
+		t.Fatalf("list to binary error:%v", err)
+	}
+
+	return &list.ProtoHeader, authSign(bin, client)
+}
+
+func TestAuthReplay(t *testing.T) {
+	client := &ClientConf{
+		ID:     1,
+		Name:   "broker",
+		role:   roleBroker,
+		secret: []byte("secret"),
+	}
+	defer func(auth AuthConf) {
+		conf.Auth = auth
+	}(conf.Auth)
+
+	conf.Auth = AuthConf{
+		Enable:  true,
+		Clients: []*ClientConf{client},
+	}
+	conf.Auth.setDefault()
+
+	hdr, bin := authTestSign(t, client)
+	if err := authCheck(hdr, bin); nil != err {
+		t.Fatalf("check error:%v", err)
+	}
+
+	// the same request again
+	if err := authCheck(hdr, bin); ErrAuth != err {
+		t.Fatalf("replayed check error:%v", err)
+	}
+
+	// a new request, new nonce
+	hdr, bin = authTestSign(t, client)
+	if err := authCheck(hdr, bin); nil != err {
+		t.Fatalf("new check error:%v", err)
+	}
+
+	// forged
+	bin[len(bin)-1] ^= 0xff
+	if err := authCheck(hdr, bin); ErrAuth != err {
+		t.Fatalf("forged check error:%v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package udfs
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	. "asdf"
+)
+
+const (
+	deftS3Region  = "us-east-1"
+	deftS3Timeout = 30 // second
+
+	s3Algorithm  = "AWS4-HMAC-SHA256"
+	s3TimeFormat = "20060102T150405Z"
+	s3DateFormat = "20060102"
+)
+
+var ErrS3 = errors.New("s3 error")
+
+// s3-compatible object storage
+// path-style url: endpoint/bucket/prefix/key
+type S3Conf struct {
+	Endpoint  string `json:"endpoint"` // http(s)://host[:port]
+	Bucket    string `json:"bucket"`
+	Region    string `json:"region"`
+	Prefix    string `json:"prefix"` // key prefix, maybe empty
+	AccessKey string `json:"accesskey"`
+	SecretKey string `json:"secretkey"`
+	Timeout   int    `json:"timeout"` // second
+}
+
+func (me *S3Conf) check() int {
+	if Empty == me.Endpoint || Empty == me.Bucket {
+		Log.Error("s3 empty endpoint or bucket")
+
+		return StdErrError
+	} else if _, err := url.Parse(me.Endpoint); nil != err {
+		Log.Error("s3 bad endpoint:%s", me.Endpoint)
+
+		return StdErrError
+	}
+
+	return 0
+}
+
+func newS3BlobStore(s3 *S3Conf) *S3BlobStore {
+	region := s3.Region
+	if Empty == region {
+		region = deftS3Region
+	}
+
+	timeout := s3.Timeout
+	if 0 == timeout {
+		timeout = deftS3Timeout
+	}
+
+	return &S3BlobStore{
+		conf:   s3,
+		region: region,
+		client: &http.Client{
+			Timeout: time.Duration(timeout) * time.Second,
+		},
+	}
+}
+
+// BlobStore of s3
+type S3BlobStore struct {
+	conf   *S3Conf
+	region string
+	client *http.Client
+}
+
+func (me *S3BlobStore) url(file UdfsFile) (*url.URL, error) {
+	key := blobKey(file)
+	if Empty != me.conf.Prefix {
+		key = strings.Trim(me.conf.Prefix, "/") + "/" + key
+	}
+
+	return url.Parse(strings.TrimRight(me.conf.Endpoint, "/") + "/" + me.conf.Bucket + "/" + key)
+}
+
+func s3Hmac(key []byte, s string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(s))
+
+	return mac.Sum(nil)
+}
+
+func s3Sha256(buf []byte) string {
+	sum := sha256.Sum256(buf)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// aws signature v4, signed headers: host, x-amz-content-sha256, x-amz-date
+func (me *S3BlobStore) sign(req *http.Request, payload []byte) {
+	now := time.Now().UTC()
+	amzTime := now.Format(s3TimeFormat)
+	amzDate := now.Format(s3DateFormat)
+	payloadHash := s3Sha256(payload)
+
+	req.Header.Set("x-amz-date", amzTime)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	if Empty == me.conf.AccessKey {
+		// anonymous
+		return
+	}
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzTime,
+		Empty,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := amzDate + "/" + me.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		amzTime,
+		scope,
+		s3Sha256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := s3Hmac([]byte("AWS4"+me.conf.SecretKey), amzDate)
+	key = s3Hmac(key, me.region)
+	key = s3Hmac(key, "s3")
+	key = s3Hmac(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm,
+		me.conf.AccessKey,
+		scope,
+		signedHeaders,
+		hex.EncodeToString(s3Hmac(key, stringToSign))))
+}
+
+// return status code and body
+func (me *S3BlobStore) do(method string, file UdfsFile, payload []byte) (int, []byte, error) {
+	u, err := me.url(file)
+	if nil != err {
+		return 0, nil, err
+	}
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(payload))
+	if nil != err {
+		return 0, nil, err
+	}
+	me.sign(req, payload)
+
+	resp, err := me.client.Do(req)
+	if nil != err {
+		Log.Error("s3 %s %s error:%v", method, u.String(), err)
+
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if nil != err {
+		return resp.StatusCode, nil, err
+	}
+
+	return resp.StatusCode, body, nil
+}
+
+func (me *S3BlobStore) Save(file UdfsFile, content []byte) error {
+	code, body, err := me.do(http.MethodPut, file, content)
+	if nil != err {
+		return err
+	} else if http.StatusOK != code {
+		Log.Error("s3 put %s status:%d %s", blobKey(file), code, string(body))
+
+		return ErrS3
+	}
+
+	return nil
+}
+
+func (me *S3BlobStore) Load(file UdfsFile) ([]byte, error) {
+	code, body, err := me.do(http.MethodGet, file, nil)
+	if nil != err {
+		return nil, err
+	}
+
+	switch code {
+	case http.StatusOK:
+		return body, nil
+	case http.StatusNotFound:
+		return nil, ErrNoExist
+	default:
+		Log.Error("s3 get %s status:%d %s", blobKey(file), code, string(body))
+
+		return nil, ErrS3
+	}
+}
+
+func (me *S3BlobStore) Touch(file UdfsFile, time Time32) error {
+	// object has no mtime to set, time is @db
+	return nil
+}
+
+func (me *S3BlobStore) Delete(file UdfsFile) error {
+	code, body, err := me.do(http.MethodDelete, file, nil)
+	if nil != err {
+		return err
+	}
+
+	switch code {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		blobSpoolDelete(file)
+
+		return nil
+	default:
+		Log.Error("s3 delete %s status:%d %s", blobKey(file), code, string(body))
+
+		return ErrS3
+	}
+}
+
+func (me *S3BlobStore) Exist(file UdfsFile) bool {
+	code, _, err := me.do(http.MethodHead, file, nil)
+
+	return nil == err && http.StatusOK == code
+}
+
+func (me *S3BlobStore) Move(from, to UdfsFile) error {
+	// key without dir
+	return nil
+}
+
+func (me *S3BlobStore) Local(file UdfsFile) (FileName, error) {
+	content, err := me.Load(file)
+	if nil != err {
+		return Empty, err
+	}
+
+	return blobSpool(file, content)
+}
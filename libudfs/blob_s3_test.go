@@ -0,0 +1,158 @@
+package udfs
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	. "asdf"
+)
+
+// fake s3 @memory, path-style: /bucket/prefix/key
+type s3Fake struct {
+	t      *testing.T
+	bucket string
+
+	lock    sync.Mutex
+	objects map[string][]byte
+}
+
+func (me *s3Fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/"+me.bucket+"/") {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), s3Algorithm+" Credential=ak/") {
+		me.t.Errorf("%s %s bad authorization:%s", r.Method, r.URL.Path, r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusForbidden)
+
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if nil != err {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	} else if r.Header.Get("x-amz-content-sha256") != s3Sha256(body) {
+		me.t.Errorf("%s %s bad payload hash", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	content, ok := me.objects[r.URL.Path]
+
+	switch r.Method {
+	case http.MethodPut:
+		me.objects[r.URL.Path] = body
+	case http.MethodGet, http.MethodHead:
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		if http.MethodGet == r.Method {
+			w.Write(content)
+		}
+	case http.MethodDelete:
+		delete(me.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newS3Test(t *testing.T) (*S3BlobStore, *s3Fake, *httptest.Server) {
+	fake := &s3Fake{
+		t:       t,
+		bucket:  "udfs",
+		objects: map[string][]byte{},
+	}
+	server := httptest.NewServer(fake)
+
+	store := newS3BlobStore(&S3Conf{
+		Endpoint:  server.URL,
+		Bucket:    fake.bucket,
+		Prefix:    "/test/",
+		AccessKey: "ak",
+		SecretKey: "sk",
+	})
+
+	return store, fake, server
+}
+
+func TestS3BlobStore(t *testing.T) {
+	store, fake, server := newS3Test(t)
+	defer server.Close()
+
+	file := UdfsFile{
+		name: FileName("/data/0/0a/0b/0a0b0c0d"),
+	}
+	content := []byte("hello udfs")
+
+	if store.Exist(file) {
+		t.Fatalf("exist before save")
+	} else if _, err := store.Load(file); ErrNoExist != err {
+		t.Fatalf("load before save error:%v", err)
+	}
+
+	if err := store.Save(file, content); nil != err {
+		t.Fatalf("save error:%v", err)
+	} else if _, ok := fake.objects["/udfs/test/0a/0b/0a0b0c0d"]; !ok {
+		t.Fatalf("bad object key:%v", fake.objects)
+	}
+
+	if !store.Exist(file) {
+		t.Fatalf("NOT exist after save")
+	}
+
+	if v, err := store.Load(file); nil != err || string(v) != string(content) {
+		t.Fatalf("load content:%s error:%v", string(v), err)
+	}
+
+	if err := store.Delete(file); nil != err {
+		t.Fatalf("delete error:%v", err)
+	} else if store.Exist(file) {
+		t.Fatalf("exist after delete")
+	}
+
+	// delete again, NOT error
+	if err := store.Delete(file); nil != err {
+		t.Fatalf("delete again error:%v", err)
+	}
+}
+
+func TestS3BlobStoreError(t *testing.T) {
+	store, _, server := newS3Test(t)
+	defer server.Close()
+
+	// no such bucket
+	store.conf.Bucket = "other"
+
+	file := UdfsFile{
+		name: FileName("/data/0/0a/0b/0a0b0c0d"),
+	}
+
+	if err := store.Save(file, []byte("x")); ErrS3 != err {
+		t.Fatalf("save error:%v", err)
+	}
+
+	// server down
+	server.Close()
+
+	if _, err := store.Load(file); nil == err {
+		t.Fatalf("load NOT error when server down")
+	} else if store.Exist(file) {
+		t.Fatalf("exist when server down")
+	}
+}
@@ -0,0 +1,280 @@
+package udfs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "asdf"
+)
+
+// blob(file content) store
+//
+// local: conf.Dirs, the default
+// memory: for test and ephemeral cache node
+// s3: s3-compatible object storage
+// volume: small files packed @volumes of conf.Dirs
+
+const (
+	blobStoreLocal  = "local"
+	blobStoreMemory = "memory"
+	blobStoreS3     = "s3"
+	blobStoreVolume = "volume"
+
+	deftBlobSpool    = "spool"
+	deftBlobSpoolAge = 3600 // second
+)
+
+var blobStore BlobStore
+
+type BlobConf struct {
+	Type     string   `json:"type"`     // local(default), memory or s3
+	Spool    FileName `json:"spool"`    // local copy for consumer, if NOT local
+	SpoolAge int      `json:"spoolage"` // second, the copy removed by gc after it
+	S3       *S3Conf  `json:"s3"`
+
+	Volume *VolumeConf `json:"volume"`
+}
+
+func (me *BlobConf) setDefault() {
+	if Empty == me.Type {
+		me.Type = blobStoreLocal
+	}
+
+	if Empty == me.Spool {
+		me.Spool = deftBlobSpool
+	}
+
+	if 0 == me.SpoolAge {
+		me.SpoolAge = deftBlobSpoolAge
+	}
+
+	if blobStoreVolume == me.Type {
+		if nil == me.Volume {
+			me.Volume = &VolumeConf{}
+		}
+
+		me.Volume.setDefault()
+	}
+}
+
+func (me *BlobConf) check() int {
+	switch me.Type {
+	case blobStoreLocal, blobStoreMemory, blobStoreVolume, Empty:
+		return 0
+	case blobStoreS3:
+		if nil == me.S3 {
+			Log.Error("blob store s3 without config")
+
+			return StdErrError
+		}
+
+		return me.S3.check()
+	default:
+		Log.Error("bad blob store:%s", me.Type)
+
+		return StdErrError
+	}
+}
+
+type BlobStore interface {
+	Save(file UdfsFile, content []byte) error
+	Load(file UdfsFile) ([]byte, error)
+	Touch(file UdfsFile, time Time32) error
+	Delete(file UdfsFile) error
+	Exist(file UdfsFile) bool
+
+	// file moved from dir to another dir
+	Move(from, to UdfsFile) error
+
+	// local filename of file, for consumer
+	Local(file UdfsFile) (FileName, error)
+}
+
+// key of file, without dir: xxxx/xxxx/digest
+func blobKey(file UdfsFile) string {
+	names := strings.Split(filepath.ToSlash(file.String()), "/")
+	if len(names) > 3 {
+		names = names[len(names)-3:]
+	}
+
+	return strings.Join(names, "/")
+}
+
+func blobSpoolPath(file UdfsFile) string {
+	return filepath.Join(conf.Blob.Spool.Abs().String(), filepath.FromSlash(blobKey(file)))
+}
+
+// copy content to spool, for consumer
+// re-copied by every pull, so mtime is the last pull
+func blobSpool(file UdfsFile, content []byte) (FileName, error) {
+	filename := blobSpoolPath(file)
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0775); nil != err {
+		return Empty, err
+	}
+
+	return FileName(filename), saveAtomic(filename, content)
+}
+
+// remove the spool copy of the deleted file, if exist
+func blobSpoolDelete(file UdfsFile) {
+	if err := os.Remove(blobSpoolPath(file)); nil != err && !os.IsNotExist(err) {
+		Log.Error("spool delete %s error:%v", blobKey(file), err)
+	}
+}
+
+// remove the spool copies NOT pulled in SpoolAge, by gc
+// return count of removed
+func blobSpoolClean(now time.Time) int {
+	count := 0
+	expire := now.Add(-time.Duration(conf.Blob.SpoolAge) * time.Second)
+
+	filepath.Walk(conf.Blob.Spool.Abs().String(), func(path string, info os.FileInfo, err error) error {
+		if nil != err || info.IsDir() || info.ModTime().After(expire) {
+			// NOT stop, the spool maybe NOT exist
+			return nil
+		}
+
+		if err := os.Remove(path); nil != err {
+			Log.Error("spool clean %s error:%v", path, err)
+		} else {
+			count++
+		}
+
+		return nil
+	})
+
+	return count
+}
+
+func newBlobStore(blob *BlobConf) BlobStore {
+	switch blob.Type {
+	case blobStoreMemory:
+		return &MemBlobStore{
+			blobs: map[string][]byte{},
+		}
+	case blobStoreS3:
+		return newS3BlobStore(blob.S3)
+	case blobStoreVolume:
+		return newVolumeBlobStore(blob.Volume)
+	default:
+		return &LocalBlobStore{}
+	}
+}
+
+func initBlobStore(role Role) {
+	if role == roleBroker {
+		blobStore = newBlobStore(&conf.Blob)
+	}
+}
+
+// files @conf.Dirs
+func blobIsLocal() bool {
+	switch blobStore.(type) {
+	case *LocalBlobStore, *VolumeBlobStore:
+		return true
+	default:
+		return false
+	}
+}
+
+// BlobStore of conf.Dirs
+type LocalBlobStore struct{}
+
+func (me *LocalBlobStore) Save(file UdfsFile, content []byte) error {
+	return file.Save(content)
+}
+
+func (me *LocalBlobStore) Load(file UdfsFile) ([]byte, error) {
+	return file.Load()
+}
+
+func (me *LocalBlobStore) Touch(file UdfsFile, time Time32) error {
+	return file.Touch(time)
+}
+
+func (me *LocalBlobStore) Delete(file UdfsFile) error {
+	return file.Delete()
+}
+
+func (me *LocalBlobStore) Exist(file UdfsFile) bool {
+	return file.Exist()
+}
+
+func (me *LocalBlobStore) Move(from, to UdfsFile) error {
+	if err := os.MkdirAll(filepath.Dir(to.String()), 0775); nil != err {
+		return err
+	}
+
+	return os.Rename(from.String(), to.String())
+}
+
+func (me *LocalBlobStore) Local(file UdfsFile) (FileName, error) {
+	return file.name, nil
+}
+
+// BlobStore of memory
+type MemBlobStore struct {
+	lock  sync.RWMutex
+	blobs map[string][]byte
+}
+
+func (me *MemBlobStore) Save(file UdfsFile, content []byte) error {
+	me.lock.Lock()
+	me.blobs[blobKey(file)] = append([]byte{}, content...)
+	me.lock.Unlock()
+
+	return nil
+}
+
+func (me *MemBlobStore) Load(file UdfsFile) ([]byte, error) {
+	me.lock.RLock()
+	content, ok := me.blobs[blobKey(file)]
+	me.lock.RUnlock()
+
+	if !ok {
+		return nil, ErrNoExist
+	}
+
+	return content, nil
+}
+
+func (me *MemBlobStore) Touch(file UdfsFile, time Time32) error {
+	// time is @db
+	return nil
+}
+
+func (me *MemBlobStore) Delete(file UdfsFile) error {
+	me.lock.Lock()
+	delete(me.blobs, blobKey(file))
+	me.lock.Unlock()
+
+	blobSpoolDelete(file)
+
+	return nil
+}
+
+func (me *MemBlobStore) Exist(file UdfsFile) bool {
+	me.lock.RLock()
+	_, ok := me.blobs[blobKey(file)]
+	me.lock.RUnlock()
+
+	return ok
+}
+
+func (me *MemBlobStore) Move(from, to UdfsFile) error {
+	// key without dir
+	return nil
+}
+
+func (me *MemBlobStore) Local(file UdfsFile) (FileName, error) {
+	content, err := me.Load(file)
+	if nil != err {
+		return Empty, err
+	}
+
+	return blobSpool(file, content)
+}
@@ -0,0 +1,592 @@
+package udfs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	. "asdf"
+)
+
+// small files packed into append-only volume files
+//
+// volume: dir/volume/xxxxxxxx(id, hex)
+// record: digest + bkdr(4) + length(4) + content
+//
+// small file is appended to the active volume of its dir, location is @DbEntry
+// big file is a normal file, the same as LocalBlobStore
+//
+// deleted record is garbage, counted @db
+// the volume(NOT active) with too much garbage is compacted:
+// live records are copied to the active volume, then the volume is removed
+
+const (
+	volumeDir = "volume"
+
+	deftVolumeSmall           = 64 << 10 // 64K
+	deftVolumeSize            = 1024     // MB
+	deftVolumeCompactRatio    = 0.5
+	deftVolumeCompactInterval = 600 // second
+
+	sizeofVolumeRecordHeader = DigestSize + 2*SizeofInt32
+	sizeofVolumeLoc          = 2*SizeofInt32 + 8
+)
+
+var ErrBadRecord = errors.New("bad volume record")
+
+var dbVolumeBucket = []byte("volumes") // id(4) ==> garbage(8)
+
+type VolumeConf struct {
+	Small           int     `json:"small"`           // max size of packed file
+	Size            int64   `json:"size"`            // MB, max size of volume
+	CompactRatio    float64 `json:"compactratio"`    // garbage/size
+	CompactInterval int     `json:"compactinterval"` // second
+}
+
+func (me *VolumeConf) setDefault() {
+	if 0 == me.Small {
+		me.Small = deftVolumeSmall
+	}
+
+	if 0 == me.Size {
+		me.Size = deftVolumeSize
+	}
+
+	if 0 == me.CompactRatio {
+		me.CompactRatio = deftVolumeCompactRatio
+	}
+
+	if 0 == me.CompactInterval {
+		me.CompactInterval = deftVolumeCompactInterval
+	}
+}
+
+// location @volume, vol 0 is NOT packed
+type VolumeLoc struct {
+	vol    uint32
+	offset uint64 // of record
+	length uint32 // of content
+}
+
+func (me *VolumeLoc) packed() bool {
+	return 0 != me.vol
+}
+
+func (me *VolumeLoc) String() string {
+	return fmt.Sprintf("vol:%x offset:%d length:%d", me.vol, me.offset, me.length)
+}
+
+func (me *VolumeLoc) ToBinary(bin []byte) error {
+	if len(bin) < sizeofVolumeLoc {
+		return ErrTooShortBuffer
+	}
+
+	Htonl(bin[0:], me.vol)
+	binary.BigEndian.PutUint64(bin[4:], me.offset)
+	Htonl(bin[12:], me.length)
+
+	return nil
+}
+
+func (me *VolumeLoc) FromBinary(bin []byte) error {
+	if len(bin) < sizeofVolumeLoc {
+		return ErrTooShortBuffer
+	}
+
+	me.vol = Ntohl(bin[0:])
+	me.offset = binary.BigEndian.Uint64(bin[4:])
+	me.length = Ntohl(bin[12:])
+
+	return nil
+}
+
+func volumePath(idir int, vol uint32) string {
+	return filepath.Join(dbConf.dirs()[idir], volumeDir, fmt.Sprintf("%08x", vol))
+}
+
+func volumeKey(vol uint32) []byte {
+	key := make([]byte, SizeofInt32)
+	Htonl(key, vol)
+
+	return key
+}
+
+// active volume of dir
+type volume struct {
+	lock sync.Mutex
+	id   uint32
+	f    *os.File
+	size int64
+}
+
+func newVolumeBlobStore(conf *VolumeConf) *VolumeBlobStore {
+	return &VolumeBlobStore{
+		conf:    conf,
+		actives: map[int]*volume{},
+	}
+}
+
+// BlobStore of volumes
+type VolumeBlobStore struct {
+	LocalBlobStore
+
+	conf    *VolumeConf
+	lock    sync.Mutex
+	actives map[int]*volume
+}
+
+func (me *VolumeBlobStore) isActive(idir int, vol uint32) bool {
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	v, ok := me.actives[idir]
+
+	return ok && v.id == vol
+}
+
+func (me *VolumeBlobStore) active(idir int) *volume {
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	v, ok := me.actives[idir]
+	if !ok {
+		v = &volume{}
+		me.actives[idir] = v
+	}
+
+	return v
+}
+
+// open new volume @dir
+func (me *VolumeBlobStore) open(idir int, v *volume) error {
+	var seq uint64
+
+	err := db.Update(func(tx MetaTx) error {
+		var err error
+
+		seq, err = tx.NextSequence(dbVolumeBucket)
+
+		return err
+	})
+	if nil != err {
+		return err
+	}
+
+	filename := volumePath(idir, uint32(seq))
+	if err = os.MkdirAll(filepath.Dir(filename), 0775); nil != err {
+		return err
+	}
+
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0664)
+	if nil != err {
+		return err
+	}
+
+	v.id = uint32(seq)
+	v.f = f
+	v.size = 0
+
+	return fsyncDir(filepath.Dir(filename))
+}
+
+// append record to the active volume of dir, return its location
+func (me *VolumeBlobStore) append(idir int, bkdr Bkdr, digest, content []byte) (VolumeLoc, error) {
+	v := me.active(idir)
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if nil == v.f {
+		if err := me.open(idir, v); nil != err {
+			Log.Error("open volume @dir:%s error:%v", dbConf.dirs()[idir], err)
+			dirReport(idir, err)
+
+			return VolumeLoc{}, err
+		}
+	}
+
+	record := make([]byte, sizeofVolumeRecordHeader+len(content))
+	copy(record, digest)
+	Htonl(record[DigestSize:], uint32(bkdr))
+	Htonl(record[DigestSize+SizeofInt32:], uint32(len(content)))
+	copy(record[sizeofVolumeRecordHeader:], content)
+
+	offset := v.size
+
+	_, err := v.f.Write(record)
+	if nil == err {
+		err = v.f.Sync()
+	}
+	dirReport(idir, err)
+
+	if nil != err {
+		Log.Error("append volume:%x error:%v", v.id, err)
+
+		// drop the broken tail
+		v.f.Truncate(offset)
+
+		return VolumeLoc{}, err
+	}
+	v.size += int64(len(record))
+
+	if v.size >= me.conf.Size<<20 {
+		// full, next is new volume
+		v.f.Close()
+		v.f = nil
+	}
+
+	return VolumeLoc{
+		vol:    v.id,
+		offset: uint64(offset),
+		length: uint32(len(content)),
+	}, nil
+}
+
+func (me *VolumeBlobStore) garbage(loc *VolumeLoc) {
+	size := uint64(sizeofVolumeRecordHeader) + uint64(loc.length)
+
+	db.Update(func(tx MetaTx) error {
+		var v [8]byte
+
+		key := volumeKey(loc.vol)
+		if old := tx.Get(dbVolumeBucket, key); len(old) == len(v) {
+			size += binary.BigEndian.Uint64(old)
+		}
+		binary.BigEndian.PutUint64(v[:], size)
+
+		return tx.Put(dbVolumeBucket, key, v[:])
+	})
+}
+
+func isPacked(file UdfsFile) bool {
+	return nil != file.loc && file.loc.packed()
+}
+
+func (me *VolumeBlobStore) Save(file UdfsFile, content []byte) error {
+	if nil == file.loc || len(content) > me.conf.Small {
+		if isPacked(file) {
+			// big now, the old is garbage
+			me.garbage(file.loc)
+			*file.loc = VolumeLoc{}
+		}
+
+		return me.LocalBlobStore.Save(file, content)
+	}
+
+	bkdr, digest, ok := parseFile(file.String())
+	if !ok {
+		return ErrBadIntf
+	}
+
+	loc, err := me.append(file.idir, bkdr, digest, content)
+	if nil != err {
+		return err
+	}
+
+	if file.loc.packed() {
+		// saved again, the old is garbage
+		me.garbage(file.loc)
+	}
+	*file.loc = loc
+
+	return nil
+}
+
+func (me *VolumeBlobStore) Load(file UdfsFile) ([]byte, error) {
+	if !isPacked(file) {
+		return me.LocalBlobStore.Load(file)
+	}
+
+	f, err := os.Open(volumePath(file.idir, file.loc.vol))
+	if nil != err {
+		dirReport(file.idir, err)
+
+		return nil, err
+	}
+	defer f.Close()
+
+	record := make([]byte, sizeofVolumeRecordHeader+int(file.loc.length))
+
+	_, err = f.ReadAt(record, int64(file.loc.offset))
+	dirReport(file.idir, err)
+	if nil != err {
+		Log.Error("load %s @volume %s error:%v", file.String(), file.loc.String(), err)
+
+		return nil, err
+	}
+
+	_, digest, _ := parseFile(file.String())
+	if !bytes.Equal(digest, record[:DigestSize]) {
+		Log.Error("load %s @volume %s bad record", file.String(), file.loc.String())
+
+		return nil, ErrBadRecord
+	}
+
+	return record[sizeofVolumeRecordHeader:], nil
+}
+
+func (me *VolumeBlobStore) Touch(file UdfsFile, time Time32) error {
+	if !isPacked(file) {
+		return me.LocalBlobStore.Touch(file, time)
+	}
+
+	// time is @db
+	return nil
+}
+
+func (me *VolumeBlobStore) Delete(file UdfsFile) error {
+	if !isPacked(file) {
+		return me.LocalBlobStore.Delete(file)
+	}
+
+	me.garbage(file.loc)
+	blobSpoolDelete(file)
+
+	return nil
+}
+
+func (me *VolumeBlobStore) Exist(file UdfsFile) bool {
+	if !isPacked(file) {
+		return me.LocalBlobStore.Exist(file)
+	}
+
+	info, err := os.Stat(volumePath(file.idir, file.loc.vol))
+
+	return nil == err && uint64(info.Size()) >= file.loc.offset+sizeofVolumeRecordHeader+uint64(file.loc.length)
+}
+
+func (me *VolumeBlobStore) Move(from, to UdfsFile) error {
+	if !isPacked(from) {
+		return me.LocalBlobStore.Move(from, to)
+	}
+
+	content, err := me.Load(from)
+	if nil != err {
+		return err
+	} else if err = me.Save(to, content); nil != err {
+		return err
+	}
+
+	return me.Delete(from)
+}
+
+func (me *VolumeBlobStore) Local(file UdfsFile) (FileName, error) {
+	if !isPacked(file) {
+		return me.LocalBlobStore.Local(file)
+	}
+
+	content, err := me.Load(file)
+	if nil != err {
+		return Empty, err
+	}
+
+	return blobSpool(file, content)
+}
+
+// the entry and the trashed(until purged) refer the record @loc, nil if not
+func volumeRefTx(tx MetaTx, bkdr Bkdr, digest []byte, idir int, loc VolumeLoc) (*DbEntry, *DbEntry, Time32) {
+	var entry *DbEntry
+
+	if v := tx.Get(dbBucket(bkdr), digest); nil != v {
+		e := &DbEntry{}
+		if nil == e.FromBinary(v) && int(e.idir) == idir && e.loc == loc {
+			entry = e
+		}
+	}
+
+	trashed, purge := dbTrashTx(tx, bkdr, digest)
+	if nil != trashed && (int(trashed.idir) != idir || trashed.loc != loc) {
+		trashed = nil
+	}
+
+	return entry, trashed, purge
+}
+
+// copy live records of volume to the active volume, then remove it
+// the record of trashed is live too, undel/purge need it
+// the volume is kept if broken, NOT lose the live records after the broken
+func (me *VolumeBlobStore) compactVolume(idir int, vol uint32) error {
+	filename := volumePath(idir, vol)
+
+	f, err := os.Open(filename)
+	if nil != err {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if nil != err {
+		return err
+	}
+	size := uint64(info.Size())
+
+	r := bufio.NewReader(f)
+	offset := uint64(0)
+	live := 0
+
+	for {
+		hdr := make([]byte, sizeofVolumeRecordHeader)
+		if _, err = io.ReadFull(r, hdr); io.EOF == err {
+			// the end
+			break
+		} else if nil != err {
+			Log.Error("compact volume:%s offset:%d broken header:%v", filename, offset, err)
+
+			return ErrBadRecord
+		}
+
+		length := uint64(Ntohl(hdr[DigestSize+SizeofInt32:]))
+		if length > size-offset-uint64(len(hdr)) {
+			Log.Error("compact volume:%s offset:%d bad length:%d", filename, offset, length)
+
+			return ErrBadRecord
+		}
+
+		content := make([]byte, length)
+		if _, err = io.ReadFull(r, content); nil != err {
+			Log.Error("compact volume:%s offset:%d broken content:%v", filename, offset, err)
+
+			return ErrBadRecord
+		}
+
+		old := VolumeLoc{
+			vol:    vol,
+			offset: offset,
+			length: uint32(len(content)),
+		}
+		offset += uint64(len(hdr) + len(content))
+
+		digest := hdr[:DigestSize]
+		bkdr := Bkdr(Ntohl(hdr[DigestSize:]))
+
+		referred := false
+		db.View(func(tx MetaTx) error {
+			entry, trashed, _ := volumeRefTx(tx, bkdr, digest, idir, old)
+			referred = nil != entry || nil != trashed
+
+			return nil
+		})
+		if !referred {
+			continue
+		}
+
+		loc, err := me.append(idir, bkdr, digest, content)
+		if nil != err {
+			// keep the volume
+			return err
+		}
+
+		err = db.Update(func(tx MetaTx) error {
+			entry, trashed, purge := volumeRefTx(tx, bkdr, digest, idir, old)
+			if nil == entry && nil == trashed {
+				// changed when compacting
+				return ErrNoExist
+			}
+
+			if nil != entry {
+				entry.loc = loc
+
+				bin, err := ToBinary(entry)
+				if nil != err {
+					return err
+				} else if err = tx.Put(dbBucket(bkdr), digest, bin); nil != err {
+					return err
+				}
+			}
+
+			if nil != trashed {
+				trashed.loc = loc
+
+				return dbTrashSetTx(tx, bkdr, digest, trashed, purge)
+			}
+
+			return nil
+		})
+		if ErrNoExist == err {
+			me.garbage(&loc)
+		} else if nil != err {
+			return err
+		}
+
+		live++
+	}
+
+	if err = os.Remove(filename); nil != err {
+		return err
+	}
+	db.Delete(dbVolumeBucket, volumeKey(vol))
+
+	Log.Info("compact volume:%s live:%d", filename, live)
+
+	return nil
+}
+
+// volumes of dir, NOT active
+func (me *VolumeBlobStore) volumes(idir int) []uint32 {
+	vols := []uint32{}
+
+	infos, err := ioutil.ReadDir(filepath.Join(dbConf.dirs()[idir], volumeDir))
+	if nil != err {
+		return vols
+	}
+
+	for _, info := range infos {
+		vol, err := strconv.ParseUint(info.Name(), 16, 32)
+		if nil != err || me.isActive(idir, uint32(vol)) {
+			continue
+		}
+
+		vols = append(vols, uint32(vol))
+	}
+
+	return vols
+}
+
+func (me *VolumeBlobStore) needCompact(idir int, vol uint32) bool {
+	info, err := os.Stat(volumePath(idir, vol))
+	if nil != err || 0 == info.Size() {
+		return nil == err
+	}
+
+	v, err := db.Get(dbVolumeBucket, volumeKey(vol))
+	if nil != err || len(v) < 8 {
+		return false
+	}
+
+	return float64(binary.BigEndian.Uint64(v))/float64(info.Size()) >= me.conf.CompactRatio
+}
+
+// broker, background
+func (me *VolumeBlobStore) compact() {
+	chTick := time.Tick(time.Duration(me.conf.CompactInterval) * time.Second)
+
+	for {
+		select {
+		case <-chTick:
+			for idir, dir := range dbConf.dirs() {
+				if Empty == dir || dirIsFailed(idir) {
+					continue
+				}
+
+				for _, vol := range me.volumes(idir) {
+					if !me.needCompact(idir, vol) {
+						continue
+					}
+
+					if err := me.compactVolume(idir, vol); nil != err {
+						Log.Error("compact volume:%s error:%v", volumePath(idir, vol), err)
+					}
+				}
+			}
+		}
+	}
+}
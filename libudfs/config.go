@@ -16,6 +16,7 @@ import (
 const (
 	etcdTimeout    = 3 * time.Second
 	deftLive       = 15552000 // 3600*24*30*6
+	deftGcGrace    = 86400    // 3600*24
 	deftDbFileName = "udfs.db"
 	deftDbConfName = "udfs.json"
 
@@ -32,9 +33,11 @@ const (
 	ENV_ETCD_NODES = "APT_ETCDS"
 
 	ENV_THIS_HOST = "THIS_HOST"
+	ENV_THIS_NODE = "THIS_NODE" // node name, if more nodes @this host
 	ENV_THIS_HOME = "APT_HOME"
 
 	ETCD_UDFS_CONFIG = "/udfs/config"
+	ETCD_UDFS_SECRET = "/udfs/secret/" // + client name, just brokers can read(etcd ACL)
 )
 
 var (
@@ -44,6 +47,7 @@ var (
 	etcdPass     string   // ENV_ETCD_PASS
 	thisHome     string   // ENV_THIS_HOME
 	thisHost     string   // ENV_THIS_HOST
+	thisNode     string   // ENV_THIS_NODE
 
 	thisNodeID = InvalidID
 	conf       = &Conf{}
@@ -52,13 +56,33 @@ var (
 // udfs config
 // load from etcd, when init
 type Conf struct {
-	Nodes       []string `json:"nodes"`
-	Dirs        []string `json:"dirs"`
-	Replication int      `json:"replication"`
-	Port        int      `json:"port"`
-	Live        Time32   `json:"live"`
-	DbFileName  FileName `json:"dbfilename"`
-	DbConfName  FileName `json:"dbconfname"`
+	Nodes         []*NodeConf   `json:"nodes"`
+	Dirs          []string      `json:"dirs"`
+	DirWeights    []int         `json:"dirweights"` // weight of dirs, for dirselect weight
+	DirSelect     string        `json:"dirselect"`  // hash(default), weight or free
+	Replication   int           `json:"replication"`
+	Port          int           `json:"port"` // default port of nodes
+	Live          Time32        `json:"live"`
+	GcGrace       Time32        `json:"gcgrace"`       // follower wait the leader gc
+	GcDryRun      bool          `json:"gcdryrun"`      // NOT gc, just log, see gc_report.go
+	TouchOnPull   bool          `json:"touchonpull"`   // touch the pulled, see access.go
+	TouchInterval Time32        `json:"touchinterval"` // min interval of touch on pull
+	AccessFlush   int           `json:"accessflush"`   // second, flush access of pull interval
+	MaxTTL        Time32        `json:"maxttl"`        // max ttl of file, default Live
+	TombLive      Time32        `json:"tomblive"`      // tombstone live
+	TrashGrace    Time32        `json:"trashgrace"`    // keep deleted file @trash, 0 is NOT trash
+	DbFileName    FileName      `json:"dbfilename"`
+	DbConfName    FileName      `json:"dbconfname"`
+	MetaStore     string        `json:"metastore"` // bolt(default) or memory
+	Blob          BlobConf      `json:"blob"`
+	Auth          AuthConf      `json:"auth"`
+	Reconcile     ReconcileConf `json:"reconcile"`
+	Migrate       MigrateConf   `json:"migrate"`
+	Health        HealthConf    `json:"health"`
+	Evict         EvictConf     `json:"evict"`
+
+	Namespaces []*NamespaceConf `json:"namespaces"`
+	Remote     *RemoteConf      `json:"remote"` // cross-cluster replication, maybe nil
 }
 
 func (me *Conf) setDefault() {
@@ -66,6 +90,31 @@ func (me *Conf) setDefault() {
 		me.Live = deftLive
 	}
 
+	if 0 == me.MaxTTL {
+		me.MaxTTL = me.Live
+	}
+
+	if 0 == me.TombLive {
+		me.TombLive = deftTombLive
+	}
+
+	if 0 == me.GcGrace {
+		me.GcGrace = deftGcGrace
+	}
+
+	if 0 == me.TouchInterval {
+		me.TouchInterval = deftTouchInterval
+	}
+
+	if 0 == me.AccessFlush {
+		// evict.flush, before access.go
+		me.AccessFlush = me.Evict.Flush
+	}
+
+	if 0 == me.AccessFlush {
+		me.AccessFlush = deftAccessFlush
+	}
+
 	if 0 == me.Port {
 		me.Port = UDFS_PORT
 	}
@@ -82,11 +131,47 @@ func (me *Conf) setDefault() {
 	if Empty == me.DbConfName {
 		me.DbConfName = deftDbConfName
 	}
+
+	for _, node := range me.Nodes {
+		node.setDefault(me.Port)
+	}
+
+	me.Blob.setDefault()
+	me.Auth.setDefault()
+	me.Reconcile.setDefault()
+	me.Migrate.setDefault()
+	me.Health.setDefault()
+	me.Evict.setDefault()
+
+	if nil != me.Remote {
+		me.Remote.setDefault()
+	}
 }
 
-func (me *Conf) findNodeID(host string) int {
+// find by name, then by host
+func (me *Conf) findNodeID(name, host string) int {
+	if Empty != name {
+		for k, v := range me.Nodes {
+			if name == v.Name {
+				return k
+			}
+		}
+
+		return InvalidID
+	}
+
 	for k, v := range me.Nodes {
-		if host == v {
+		if host == v.Host {
+			return k
+		}
+	}
+
+	return InvalidID
+}
+
+func (me *Conf) findDir(dir string) int {
+	for k, v := range me.Dirs {
+		if dir == v {
 			return k
 		}
 	}
@@ -105,6 +190,20 @@ func (me *Conf) check() int {
 		return StdErrError
 	}
 
+	for i, node := range me.Nodes {
+		if errno := node.check(); 0 != errno {
+			return errno
+		}
+
+		for _, other := range me.Nodes[:i] {
+			if other.Name == node.Name {
+				Log.Error("node:%s dup", node.Name)
+
+				return StdErrError
+			}
+		}
+	}
+
 	count := len(me.Dirs)
 	for i := 0; i < count; i++ {
 		dir := FileName(me.Dirs[i])
@@ -116,13 +215,33 @@ func (me *Conf) check() int {
 		}
 	}
 
-	return 0
+	if errno := checkDirSelect(me.DirSelect, me.DirWeights, count); 0 != errno {
+		return errno
+	}
+
+	if errno := me.Evict.check(count); 0 != errno {
+		return errno
+	}
+
+	if errno := me.checkNamespaces(); 0 != errno {
+		return errno
+	}
+
+	if errno := me.Blob.check(); 0 != errno {
+		return errno
+	}
+
+	if errno := me.Auth.check(); 0 != errno {
+		return errno
+	}
+
+	return me.Reconcile.check()
 }
 
 func initThisNodeID() {
-	thisNodeID = conf.findNodeID(thisHost)
+	thisNodeID = conf.findNodeID(thisNode, thisHost)
 	if InvalidID == thisNodeID {
-		Log.Error("etcd config nodes not include this-host:%s", thisHost)
+		Log.Error("etcd config nodes not include this-host:%s this-node:%s", thisHost, thisNode)
 
 		panic(StdErrError)
 	}
@@ -142,7 +261,6 @@ func getEtcd(path string, timeout time.Duration) ([]byte, error) {
 	}
 	defer cli.Close()
 
-	// get etcd path ETCD_UDFS_CONFIG
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	resp, err := cli.Get(ctx, path)
 	cancel()
@@ -151,15 +269,14 @@ func getEtcd(path string, timeout time.Duration) ([]byte, error) {
 
 		return nil, err
 	} else if 1 != resp.Count {
-		Log.Error("get etcd:%s more kvs", ETCD_UDFS_CONFIG)
+		Log.Error("get etcd:%s more kvs", path)
 
 		return nil, errors.New("etcd more kvs")
 	} else {
-		buf := resp.Kvs[0].Value
-
-		Log.Info("get etcd:%s value:%s", ETCD_UDFS_CONFIG, string(buf))
+		// NOT log the value, maybe secret
+		Log.Info("get etcd:%s", path)
 
-		return buf, nil
+		return resp.Kvs[0].Value, nil
 	}
 }
 
@@ -168,6 +285,7 @@ func initConf() {
 	if nil != err {
 		panic(StdErrError)
 	}
+	Log.Info("etcd config:%s", string(buf))
 
 	err = json.Unmarshal(buf, conf)
 	if nil != err {
@@ -183,9 +301,9 @@ func initConf() {
 }
 
 func getEnv(name string) string {
-	v := os.Getenv(ENV_THIS_HOME)
+	v := os.Getenv(name)
 	if Empty == v {
-		Log.Error("no ENV:%s", ENV_THIS_HOME)
+		Log.Error("no ENV:%s", name)
 
 		panic(StdErrNoEnv)
 	}
@@ -197,6 +315,7 @@ func initEnv() {
 	thisHome = getEnv(ENV_THIS_HOME)
 	thisHost = getEnv(ENV_THIS_HOST)
 	etcdNodeList = getEnv(ENV_ETCD_NODES)
+	thisNode = os.Getenv(ENV_THIS_NODE)
 	etcdUser = os.Getenv(ENV_ETCD_USER)
 	etcdPass = os.Getenv(ENV_ETCD_PASS)
 
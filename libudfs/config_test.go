@@ -0,0 +1,47 @@
+package udfs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func confTestCheck(t *testing.T, nodes string) int {
+	dir, err := ioutil.TempDir("", "udfs")
+	if nil != err {
+		t.Fatalf("temp dir error:%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	dirs, _ := json.Marshal([]string{dir})
+
+	conf = &Conf{}
+	if err := json.Unmarshal([]byte(`{"nodes":`+nodes+`,"dirs":`+string(dirs)+`}`), conf); nil != err {
+		t.Fatalf("unmarshal nodes:%s error:%v", nodes, err)
+	}
+
+	return conf.check()
+}
+
+func TestConfStringNodes(t *testing.T) {
+	if errno := confTestCheck(t, `["10.0.0.1", "10.0.0.2", "10.0.0.3:8291"]`); 0 != errno {
+		t.Fatalf("check errno:%d", errno)
+	}
+
+	conf.setDefault()
+	for i, name := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3:8291"} {
+		if node := conf.Nodes[i]; name != node.Name {
+			t.Fatalf("node:%d name:%s", i, node.Name)
+		}
+	}
+
+	// mixed string and object
+	if errno := confTestCheck(t, `["10.0.0.1", {"name":"b", "host":"10.0.0.1", "port":8291}]`); 0 != errno {
+		t.Fatalf("mixed check errno:%d", errno)
+	}
+
+	if errno := confTestCheck(t, `["10.0.0.1", "10.0.0.1"]`); 0 == errno {
+		t.Fatalf("dup nodes NOT failed")
+	}
+}
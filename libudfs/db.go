@@ -3,12 +3,11 @@ package udfs
 import (
 	. "asdf"
 	"encoding/hex"
-	"os"
-
-	"github.com/boltdb/bolt"
 )
 
-var db *bolt.DB
+const dbScanBatch = 128
+
+var db MetaStore
 
 func newbkdr(bkdr Bkdr, digest []byte) Bkdr {
 	if 0 == bkdr {
@@ -42,30 +41,9 @@ func dbBucket(bkdr Bkdr) []byte {
 	return bucket[:]
 }
 
-func dbGc(bucket []byte, fgc func(file UdfsFile)) {
-	now := NowTime32()
-
-	db.Batch(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucket)
-		if nil == b {
-			return nil
-		}
-
-		b.ForEach(func(k, v []byte) error {
-			e := &DbEntry{}
-			e.FromBinary(v)
-
-			if e.time+conf.Live < now {
-				b.Delete(k)
-
-				go fgc(dbConf.File(e.bkdr, e.digest[:]))
-			}
-
-			return nil
-		})
-
-		return nil
-	})
+// other buckets(xlog...) has longer name
+func dbIsBucket(name []byte) bool {
+	return len(name) == len(dbBucket(0))
 }
 
 func dbExist(bkdr Bkdr, digest []byte) bool {
@@ -79,13 +57,8 @@ func dbGet(bkdr Bkdr, digest []byte) (*DbEntry, error) {
 
 	bkdr = newbkdr(bkdr, digest)
 
-	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(dbBucket(bkdr))
-		if nil == b {
-			return ErrNoExist
-		}
-
-		v := b.Get(digest)
+	err := db.View(func(tx MetaTx) error {
+		v := tx.Get(dbBucket(bkdr), digest)
 		if nil == v {
 			return ErrNoExist
 		}
@@ -101,49 +74,63 @@ func dbGet(bkdr Bkdr, digest []byte) (*DbEntry, error) {
 	}
 }
 
-func dbAdd(bkdr Bkdr, digest []byte, mtime Time32) (*DbEntry, error) {
+// file of entry
+// if entry not exist, the file of new entry
+func dbFile(bkdr Bkdr, digest []byte) UdfsFile {
+	entry, _ := dbEntry(bkdr, digest, nsDefault)
+
+	return entry.File()
+}
+
+// get entry
+// if not exist, new entry(not saved) @the dir of ns
+func dbEntry(bkdr Bkdr, digest []byte, ns byte) (*DbEntry, bool) {
 	bkdr = newbkdr(bkdr, digest)
-	mtime = newtime32(mtime)
+
+	if entry, _ := dbGet(bkdr, digest); nil != entry {
+		return entry, true
+	}
+
+	// no dir, push/repair check it before saving the file
+	idir, _ := dbConf.idir(bkdr, ns)
 
 	entry := &DbEntry{
-		time: mtime,
+		FileAttr: FileAttr{
+			ns: ns,
+		},
 		bkdr: bkdr,
-		idir: dbConf.idir(bkdr),
+		idir: idir,
 	}
 	copy(entry.digest[:], digest)
 
-	bin, err := ToBinary(entry)
-	if nil != err {
-		return nil, err
-	}
+	return entry, false
+}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists(dbBucket(bkdr))
-		if nil != err {
-			return err
-		}
+// add or update entry
+// if entry exist, keep its dir and namespace
+func dbAdd(bkdr Bkdr, digest []byte, mtime Time32, ns byte) (*DbEntry, error) {
+	entry, _ := dbEntry(bkdr, digest, ns)
+	entry.time = newtime32(mtime)
+
+	return entry, dbPut(entry)
+}
 
-		return b.Put(digest, bin)
+func dbPut(entry *DbEntry) error {
+	err := db.Update(func(tx MetaTx) error {
+		return dbPutTx(tx, entry)
 	})
 	if nil != err {
 		Log.Error("db add %s error:%v", entry.String(), err.Error())
-
-		return nil, err
-	} else {
-		return entry, nil
 	}
+
+	return err
 }
 
 func dbDel(bkdr Bkdr, digest []byte) error {
 	bkdr = newbkdr(bkdr, digest)
 
-	err := db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(dbBucket(bkdr))
-		if nil != b {
-			return b.Delete(digest)
-		} else {
-			return nil
-		}
+	err := db.Update(func(tx MetaTx) error {
+		return dbDelTx(tx, bkdr, digest)
 	})
 	if nil != err {
 		Log.Error("db del bkdr:%x digest:%s error:%v", bkdr, hex.EncodeToString(digest), err.Error())
@@ -152,41 +139,170 @@ func dbDel(bkdr Bkdr, digest []byte) error {
 	return err
 }
 
-func dbDiskLoadBalance() error {
-	entry := &DbEntry{}
+// data bucket names
+func dbBuckets(tx MetaTx) [][]byte {
+	names := [][]byte{}
+
+	tx.Buckets(func(name []byte) error {
+		if dbIsBucket(name) {
+			names = append(names, append([]byte{}, name...))
+		}
 
-	entryHandle := func(k, v []byte) error {
-		if err := entry.FromBinary(v); nil == err {
-			oldPath := dbConfOld.path(entry.bkdr)
-			newPath := dbConf.path(entry.bkdr)
-			os.MkdirAll(newPath.String(), 0775)
+		return nil
+	})
+
+	return names
+}
 
-			oldFile := dbConfOld.file(oldPath, entry.digest[:])
-			newFile := dbConf.file(newPath, entry.digest[:])
+// scan entries from checkpoint(bucket+digest, nil is the first)
+// return matched entries(at most dbScanBatch), and the next checkpoint
+// the next == checkpoint: the end
+func dbScanEntries(checkpoint []byte, match func(entry *DbEntry) bool) ([]*DbEntry, []byte) {
+	entries := []*DbEntry{}
+	next := checkpoint
+
+	db.View(func(tx MetaTx) error {
+		for _, bucket := range dbBuckets(tx) {
+			var begin []byte
+
+			if len(checkpoint) > len(bucket) {
+				if string(bucket) < string(checkpoint[:len(bucket)]) {
+					continue
+				} else if string(bucket) == string(checkpoint[:len(bucket)]) {
+					begin = checkpoint[len(bucket):]
+				}
+			}
 
-			os.Rename(oldFile.String(), newFile.String())
+			err := tx.ForEach(bucket, begin, func(k, v []byte) error {
+				if nil != begin && string(k) == string(begin) {
+					// done @last batch
+					return nil
+				}
+
+				next = append(append([]byte{}, bucket...), k...)
+
+				entry := &DbEntry{}
+				if err := entry.FromBinary(v); nil != err {
+					return nil
+				} else if match(entry) {
+					entries = append(entries, entry)
+				}
+
+				if len(entries) >= dbScanBatch {
+					return ErrStop
+				}
+
+				return nil
+			})
+			if nil != err || len(entries) >= dbScanBatch {
+				return err
+			}
 		}
 
 		return nil
-	}
+	})
+
+	return entries, next
+}
+
+// scan buckets from checkpoint(bucket+key, nil is the first), for the paged listing
+// handle return counted(at most dbScanBatch of a page), ErrStop: the end
+// return the next checkpoint, nil: the end
+func dbScanTx(tx MetaTx, buckets [][]byte, checkpoint []byte, handle func(bucket, k, v []byte) (bool, error)) ([]byte, error) {
+	var next []byte
+
+	count := 0
+	end := false
+
+	for _, bucket := range buckets {
+		var begin []byte
+
+		if len(checkpoint) > len(bucket) {
+			if string(bucket) < string(checkpoint[:len(bucket)]) {
+				continue
+			} else if string(bucket) == string(checkpoint[:len(bucket)]) {
+				begin = checkpoint[len(bucket):]
+			}
+		}
+
+		err := tx.ForEach(bucket, begin, func(k, v []byte) error {
+			if nil != begin && string(k) == string(begin) {
+				// done @last page
+				return nil
+			}
+
+			counted, err := handle(bucket, k, v)
+			if ErrStop == err {
+				end = true
+
+				return ErrStop
+			} else if nil != err {
+				return err
+			} else if counted {
+				count++
+			}
+
+			next = append(append([]byte{}, bucket...), k...)
+			if count >= dbScanBatch {
+				return ErrStop
+			}
 
-	bucketHandle := func(name []byte, b *bolt.Bucket) error {
-		return b.ForEach(entryHandle)
+			return nil
+		})
+		if nil != err {
+			return nil, err
+		} else if end {
+			return nil, nil
+		} else if count >= dbScanBatch {
+			return next, nil
+		}
 	}
 
-	return db.View(func(tx *bolt.Tx) error {
-		return tx.ForEach(bucketHandle)
+	return nil, nil
+}
+
+// update idir and loc of entry to the moved, if NOT changed by others
+// ErrNoExist if entry deleted
+// by dbPutTx, the lru key(with idir) is moved too
+func dbMoveEntry(entry, moved *DbEntry) error {
+	bucket := dbBucket(entry.bkdr)
+
+	return db.Update(func(tx MetaTx) error {
+		v := tx.Get(bucket, entry.digest[:])
+		if nil == v {
+			return ErrNoExist
+		}
+
+		e := &DbEntry{}
+		if err := e.FromBinary(v); nil != err {
+			return err
+		} else if e.idir != entry.idir || e.loc != entry.loc {
+			// moved by others
+			return nil
+		}
+		e.idir = moved.idir
+		e.loc = moved.loc
+
+		return dbPutTx(tx, e)
 	})
 }
 
 // just for publisher/broker
 func initDb(role Role) {
 	if role != roleConsumer {
-		bdb, err := bolt.Open(conf.DbFileName.Abs().String(), 0755, nil)
+		store, err := newMetaStore(conf.MetaStore)
 		if nil != err {
 			panic(StdErrBadFile)
 		}
 
-		db = bdb
+		db = store
+
+		if err = dbMigrate(); nil != err {
+			panic(StdErrBadFile)
+		}
+
+		if err = dbExpireCheck(); nil != err {
+			panic(StdErrBadFile)
+		}
 	}
 }
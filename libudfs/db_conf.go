@@ -5,28 +5,75 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"path/filepath"
+	"sync"
 )
 
 var dbConf = &DbConf{}
-var dbConfOld = &DbConf{}
 
+// Dirs maybe has holes(Empty), the removed dirs
+// replaced by disk migration @background, read it by dirs()
 type DbConf struct {
-	dirs []string `json:"dirs"`
+	Dirs []string `json:"dirs"`
+
+	lock sync.RWMutex
+}
+
+// snapshot of Dirs, NOT modify it
+func (me *DbConf) dirs() []string {
+	me.lock.RLock()
+	defer me.lock.RUnlock()
+
+	return me.Dirs
 }
 
-func (me *DbConf) idir(bkdr Bkdr) byte {
-	return byte(bkdr % Bkdr(len(me.dirs)))
+// the dir of idir is known, NOT the hole of a removed dir
+func (me *DbConf) has(idir byte) bool {
+	dirs := me.dirs()
+
+	return int(idir) < len(dirs) && Empty != dirs[idir]
 }
 
-func (me *DbConf) path(bkdr Bkdr) UdfsFile {
+// replace Dirs, NOT modify the old in place
+func (me *DbConf) setDirs(dirs []string) {
+	me.lock.Lock()
+	me.Dirs = dirs
+	me.lock.Unlock()
+}
+
+// dir of new file, see dir_select.go
+// ErrNoDir if all dirs failed/removed
+func (me *DbConf) idir(bkdr Bkdr, ns byte) (byte, error) {
+	candidates := []int{}
+	dirs := me.dirs()
+
+	// namespace's dirs
+	for _, dir := range conf.nsDirs(ns) {
+		for k, v := range dirs {
+			if dir == v && me.active(k) {
+				candidates = append(candidates, k)
+			}
+		}
+	}
+
+	if 0 == len(candidates) {
+		for k := range dirs {
+			if me.active(k) {
+				candidates = append(candidates, k)
+			}
+		}
+	}
+
+	return me.selectDir(dirs, bkdr, candidates)
+}
+
+func (me *DbConf) path(bkdr Bkdr, idir byte) UdfsFile {
 	var b [4]byte
 	var s [8]byte
 
 	binary.BigEndian.PutUint32(b[:], uint32(bkdr))
 	hex.Encode(s[:], b[:])
 
-	idir := me.idir(bkdr)
-	path := filepath.Join(dbConf.dirs[idir], string(s[0:4]), string(s[4:8]))
+	path := filepath.Join(me.dirs()[idir], string(s[0:4]), string(s[4:8]))
 
 	return UdfsFile{
 		name: FileName(path),
@@ -43,22 +90,25 @@ func (me *DbConf) file(path UdfsFile, digest []byte) UdfsFile {
 	}
 }
 
-func (me *DbConf) File(bkdr Bkdr, digest []byte) UdfsFile {
-	return me.file(me.path(bkdr), digest)
+func (me *DbConf) File(bkdr Bkdr, digest []byte, idir byte) UdfsFile {
+	return me.file(me.path(bkdr, idir), digest)
 }
 
+// the same dirs with conf, ignore order and holes
 func (me *DbConf) eq() bool {
-	if len(me.dirs) != len(conf.Dirs) {
-		return false
-	}
+	count := 0
 
-	for i, dir := range me.dirs {
-		if dir != conf.Dirs[i] {
+	for k, dir := range me.dirs() {
+		if Empty == dir {
+			continue
+		} else if me.removed(k) {
 			return false
 		}
+
+		count++
 	}
 
-	return true
+	return count == len(conf.Dirs)
 }
 
 func initDbConf(role Role) error {
@@ -70,17 +120,27 @@ func initDbConf(role Role) error {
 			return err
 		}
 
-		if !dbConf.eq() {
+		if role == roleBroker {
 			// self is broker
-			// db config != etcd config
-			// disk load balance
-			if err = dbDiskLoadBalance(); nil != err {
+			// db config != etcd config, or migration NOT finished
+			// disk migrate @background, see disk_migrate.go
+			pending, err := diskMigrateInit()
+			if nil != err {
 				return err
+			} else if !pending {
+				return nil
+			}
+
+			if !dbConf.eq() {
+				diskMigrating = true
+			} else {
+				// just dirs added, nothing to move
+				return diskMigrateFinish()
 			}
 		}
 	} else if role != roleConsumer {
 		// db config NOT exist, load it from etcd
-		dbConf.dirs = conf.Dirs
+		dbConf.setDirs(conf.Dirs)
 
 		err := filename.SaveJson(dbConf)
 		if nil != err {
@@ -2,29 +2,101 @@ package udfs
 
 import (
 	. "asdf"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 )
 
+// DbEntry binary version
+//
+// v0: time(4) + bkdr(4) + idir(1) + digest + [ns(1)]
+// no version byte, size is sizeofDbEntryV0 or sizeofDbEntryV0+1
+//
+// v1: version(1) + time(4) + bkdr(4) + idir(1) + ns(1) + size(8) + digest + tlv...
+// tlv is ProtoExt of FileAttr, and VolumeLoc if packed, atime if pulled
+const (
+	dbEntryV0      = 0
+	dbEntryV1      = 1
+	dbEntryVersion = dbEntryV1
+)
+
+var ErrBadEntryVersion = errors.New("bad db entry version")
+
 type DbEntry struct {
+	FileAttr
+
 	time   Time32
 	bkdr   Bkdr
 	idir   byte
 	digest [DigestSize]byte
+	loc    VolumeLoc
+	atime  Time32 // last pull @local, for eviction
 }
 
 func (me *DbEntry) String() string {
-	return fmt.Sprintf("time:%v bkdr:%x dir:%d digest:%s",
+	return fmt.Sprintf("time:%v bkdr:%x dir:%d digest:%s %s",
 		me.time.Unix(),
 		me.bkdr,
 		me.idir,
-		hex.EncodeToString(me.digest[:]))
+		hex.EncodeToString(me.digest[:]),
+		me.FileAttr.String())
+}
+
+// file.loc is &me.loc, VolumeBlobStore.Save set it
+func (me *DbEntry) File() UdfsFile {
+	file := dbConf.File(me.bkdr, me.digest[:], me.idir)
+	file.loc = &me.loc
+
+	return file
+}
+
+func (me *DbEntry) exts() []ProtoExt {
+	exts := me.FileAttr.exts()
+
+	if me.loc.packed() {
+		value := make([]byte, sizeofVolumeLoc)
+		me.loc.ToBinary(value)
+
+		exts = append(exts, ProtoExt{
+			tag:   extVolume,
+			value: value,
+		})
+	}
+
+	if 0 != me.atime {
+		exts = append(exts, extUint32(extAtime, uint32(me.atime)))
+	}
+
+	return exts
+}
+
+func (me *DbEntry) ext(tag byte, value []byte) error {
+	if extVolume == tag {
+		return me.loc.FromBinary(value)
+	} else if extAtime == tag {
+		if len(value) < SizeofInt32 {
+			return ErrTooShortBuffer
+		}
+		me.atime = Time32(Ntohl(value))
+
+		return nil
+	}
+
+	return me.FileAttr.ext(tag, value)
 }
 
-const sizeofDbEntry = SizeofByte + 2*SizeofInt32 + DigestSize
+func (me *DbEntry) expired(now Time32) bool {
+	return !me.pinned && me.expireAt(me.time) < now
+}
+
+const (
+	sizeofDbEntryV0    = SizeofByte + 2*SizeofInt32 + DigestSize
+	sizeofDbEntryFixed = 3*SizeofByte + 4*SizeofInt32 + DigestSize
+)
 
 func (me *DbEntry) Size() int {
-	return sizeofDbEntry
+	return sizeofDbEntryFixed + extSize(me.exts())
 }
 
 func (me *DbEntry) ToBinary(bin []byte) error {
@@ -32,17 +104,49 @@ func (me *DbEntry) ToBinary(bin []byte) error {
 		return ErrTooShortBuffer
 	}
 
-	Htonl(bin[0:], uint32(me.time))
-	Htonl(bin[4:], uint32(me.bkdr))
-	bin[8] = byte(me.idir)
+	bin[0] = dbEntryVersion
+	Htonl(bin[1:], uint32(me.time))
+	Htonl(bin[5:], uint32(me.bkdr))
+	bin[9] = me.idir
+	bin[10] = me.ns
+	binary.BigEndian.PutUint64(bin[11:], me.size)
 
-	copy(bin[9:], me.digest[:])
+	copy(bin[19:], me.digest[:])
 
-	return nil
+	return extToBinary(bin[sizeofDbEntryFixed:], me.exts())
 }
 
 func (me *DbEntry) FromBinary(bin []byte) error {
-	if len(bin) < me.Size() {
+	if version, err := dbEntryVersionOf(bin); nil != err {
+		return err
+	} else if dbEntryV0 == version {
+		return me.fromBinaryV0(bin)
+	} else if len(bin) < sizeofDbEntryFixed {
+		return ErrTooShortBuffer
+	}
+
+	me.time = Time32(Ntohl(bin[1:]))
+	me.bkdr = Bkdr(Ntohl(bin[5:]))
+	me.idir = bin[9]
+	me.ns = bin[10]
+	me.size = binary.BigEndian.Uint64(bin[11:])
+
+	copy(me.digest[:], bin[19:])
+
+	me.ctype = Empty
+	me.meta = nil
+	me.ttl = 0
+	me.expire = 0
+	me.pinned = false
+	me.client = 0
+	me.loc = VolumeLoc{}
+	me.atime = 0
+
+	return extFromBinary(bin[sizeofDbEntryFixed:], me.ext)
+}
+
+func (me *DbEntry) fromBinaryV0(bin []byte) error {
+	if len(bin) < sizeofDbEntryV0 {
 		return ErrTooShortBuffer
 	}
 
@@ -52,5 +156,28 @@ func (me *DbEntry) FromBinary(bin []byte) error {
 
 	copy(me.digest[:], bin[9:])
 
+	me.FileAttr = FileAttr{}
+	me.loc = VolumeLoc{}
+	if len(bin) > sizeofDbEntryV0 {
+		me.ns = bin[sizeofDbEntryV0]
+	}
+
 	return nil
 }
+
+// v0 has no version byte, check it by size
+// ErrBadEntryVersion if newer than dbEntryVersion, written by the newer udfs
+func dbEntryVersionOf(bin []byte) (byte, error) {
+	switch len(bin) {
+	case 0:
+		return 0, ErrTooShortBuffer
+	case sizeofDbEntryV0, sizeofDbEntryV0 + SizeofByte:
+		return dbEntryV0, nil
+	}
+
+	if version := bin[0]; dbEntryV0 == version || version > dbEntryVersion {
+		return version, ErrBadEntryVersion
+	} else {
+		return version, nil
+	}
+}
@@ -0,0 +1,76 @@
+package udfs
+
+import (
+	"testing"
+
+	. "asdf"
+)
+
+// v0 record: time(4) + bkdr(4) + idir(1) + digest + [ns(1)]
+func dbEntryTestV0(ns bool) []byte {
+	bin := make([]byte, sizeofDbEntryV0)
+
+	Htonl(bin[0:], 0x5a000001)
+	Htonl(bin[4:], 0x00010002)
+	bin[8] = 3
+	for i := 0; i < DigestSize; i++ {
+		bin[9+i] = byte(i + 1)
+	}
+
+	if ns {
+		bin = append(bin, 4)
+	}
+
+	return bin
+}
+
+func TestDbEntryV0(t *testing.T) {
+	for _, ns := range []bool{false, true} {
+		bin := dbEntryTestV0(ns)
+		if n := len(bin); (ns && 42 != n) || (!ns && 41 != n) {
+			t.Fatalf("v0 size:%d", n)
+		}
+
+		if version, err := dbEntryVersionOf(bin); nil != err || dbEntryV0 != version {
+			t.Fatalf("v0 version:%d error:%v", version, err)
+		}
+
+		entry := &DbEntry{}
+		if err := entry.FromBinary(bin); nil != err {
+			t.Fatalf("v0 from binary error:%v", err)
+		} else if 0x5a000001 != entry.time || 0x00010002 != entry.bkdr || 3 != entry.idir || 32 != entry.digest[DigestSize-1] {
+			t.Fatalf("v0 entry:%s", entry.String())
+		} else if ns && 4 != entry.ns || !ns && 0 != entry.ns {
+			t.Fatalf("v0 ns:%d", entry.ns)
+		}
+
+		// v0 ==> v1 ==> entry
+		v1, err := ToBinary(entry)
+		if nil != err {
+			t.Fatalf("to v1 error:%v", err)
+		} else if version, err := dbEntryVersionOf(v1); nil != err || dbEntryV1 != version {
+			t.Fatalf("v1 version:%d error:%v", version, err)
+		}
+
+		again := &DbEntry{}
+		if err := again.FromBinary(v1); nil != err {
+			t.Fatalf("v1 from binary error:%v", err)
+		} else if again.String() != entry.String() {
+			t.Fatalf("v1 entry:%s NOT v0 entry:%s", again.String(), entry.String())
+		}
+	}
+}
+
+func TestDbEntryBadVersion(t *testing.T) {
+	entry := &DbEntry{}
+
+	if err := entry.FromBinary(nil); nil == err {
+		t.Fatalf("empty NOT failed")
+	}
+
+	bin := make([]byte, sizeofDbEntryFixed)
+	bin[0] = dbEntryVersion + 1
+	if err := entry.FromBinary(bin); ErrBadEntryVersion != err {
+		t.Fatalf("newer version error:%v", err)
+	}
+}
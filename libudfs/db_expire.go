@@ -0,0 +1,306 @@
+package udfs
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	. "asdf"
+)
+
+// expiry index
+//
+// bucket expire: expire(4) + bkdr(4) + digest ==> empty
+// ordered by expire time, gc just iterate the expired prefix
+//
+// updated with the entry @the same tx(dbPutTx/dbDelTx)
+// the postponed key(see dbGc) maybe stale, removed by dbGc
+// rebuilt if live of conf/namespaces changed, the signature is @meta
+
+const (
+	dbGcBatch = 1024
+
+	sizeofDbExpireKey = 2*SizeofInt32 + DigestSize
+)
+
+var (
+	dbExpireBucket = []byte("expire")
+	dbMetaLive     = []byte("expirelive")
+)
+
+func dbExpireKey(entry *DbEntry) []byte {
+	key := make([]byte, sizeofDbExpireKey)
+
+	Htonl(key[0:], uint32(entry.expireAt(entry.time)))
+	Htonl(key[4:], uint32(entry.bkdr))
+	copy(key[8:], entry.digest[:])
+
+	return key
+}
+
+// put entry and its expiry/lru key, remove the old keys
+// usage of quota is counted if new entry
+func dbPutTx(tx MetaTx, entry *DbEntry) error {
+	bucket := dbBucket(entry.bkdr)
+
+	if v := tx.Get(bucket, entry.digest[:]); nil != v {
+		old := &DbEntry{}
+		if nil == old.FromBinary(v) {
+			if err := tx.Delete(dbExpireBucket, dbExpireKey(old)); nil != err {
+				return err
+			} else if err = tx.Delete(dbLruBucket, dbLruKey(old)); nil != err {
+				return err
+			}
+		}
+	} else if err := dbQuotaAddTx(tx, &entry.FileAttr, 1); nil != err {
+		// new entry
+		return err
+	}
+
+	if err := dbPinIndexTx(tx, entry); nil != err {
+		return err
+	}
+
+	bin, err := ToBinary(entry)
+	if nil != err {
+		return err
+	} else if err = tx.Put(bucket, entry.digest[:], bin); nil != err {
+		return err
+	}
+
+	if tomb := dbTombTx(tx, entry.bkdr, entry.digest[:]); 0 != tomb && tomb <= entry.time {
+		// pushed again after del
+		if err = tx.Delete(dbTombBucket, dbTombKey(entry.bkdr, entry.digest[:])); nil != err {
+			return err
+		}
+	}
+
+	if entry.pinned {
+		// NOT gc, NOT evict
+		return nil
+	} else if err = tx.Put(dbLruBucket, dbLruKey(entry), []byte{}); nil != err {
+		return err
+	}
+
+	return tx.Put(dbExpireBucket, dbExpireKey(entry), []byte{})
+}
+
+// delete entry and its expiry key
+func dbDelTx(tx MetaTx, bkdr Bkdr, digest []byte) error {
+	bucket := dbBucket(bkdr)
+
+	v := tx.Get(bucket, digest)
+	if nil == v {
+		return nil
+	}
+
+	old := &DbEntry{}
+	if nil == old.FromBinary(v) {
+		if err := tx.Delete(dbExpireBucket, dbExpireKey(old)); nil != err {
+			return err
+		} else if err = tx.Delete(dbLruBucket, dbLruKey(old)); nil != err {
+			return err
+		} else if err = dbQuotaAddTx(tx, &old.FileAttr, -1); nil != err {
+			return err
+		}
+	}
+
+	if err := tx.Delete(dbPinBucket, dbPinKey(bkdr, digest)); nil != err {
+		return err
+	}
+
+	return tx.Delete(bucket, digest)
+}
+
+// the expired entries, at most dbGcBatch
+//
+// lead: the bkdr's leader is self, should del it @group
+// late: NOT leader, postponed grace and the leader NOT del it, del it @local
+//
+// the expired NOT lead is postponed(key time+grace) @the first time
+// the entries are NOT deleted here
+func dbGc(now, grace Time32, lead func(bkdr Bkdr) bool) ([]*DbEntry, []*DbEntry) {
+	leads := []*DbEntry{}
+	lates := []*DbEntry{}
+
+	err := db.Update(func(tx MetaTx) error {
+		keys := [][]byte{}
+		leads = leads[:0]
+		lates = lates[:0]
+
+		tx.ForEach(dbExpireBucket, nil, func(k, v []byte) error {
+			if len(k) != sizeofDbExpireKey {
+				keys = append(keys, append([]byte{}, k...))
+			} else if Time32(Ntohl(k)) >= now || len(keys) >= dbGcBatch {
+				return ErrStop
+			} else {
+				keys = append(keys, append([]byte{}, k...))
+			}
+
+			return nil
+		})
+
+		// NOT modify @ForEach
+		for _, k := range keys {
+			if len(k) != sizeofDbExpireKey {
+				tx.Delete(dbExpireBucket, k)
+
+				continue
+			}
+
+			bkdr := Bkdr(Ntohl(k[4:]))
+			digest := k[8:]
+
+			e := &DbEntry{}
+			if v := tx.Get(dbBucket(bkdr), digest); nil == v || nil != e.FromBinary(v) {
+				// stale key
+				tx.Delete(dbExpireBucket, k)
+
+				continue
+			}
+
+			if e.pinned {
+				// pinned after indexed
+				tx.Delete(dbExpireBucket, k)
+
+				continue
+			}
+
+			key := dbExpireKey(e)
+			postponed := append([]byte{}, key...)
+			Htonl(postponed, Ntohl(key)+uint32(grace))
+
+			switch {
+			case !e.expired(now):
+				// live changed, index it again
+				tx.Delete(dbExpireBucket, k)
+				tx.Put(dbExpireBucket, key, []byte{})
+			case lead(bkdr):
+				leads = append(leads, e)
+			case string(k) == string(key):
+				// postpone it, wait the leader
+				tx.Delete(dbExpireBucket, k)
+				tx.Put(dbExpireBucket, postponed, []byte{})
+			case string(k) == string(postponed):
+				lates = append(lates, e)
+			default:
+				// stale postponed key, the entry is touched
+				tx.Delete(dbExpireBucket, k)
+				tx.Put(dbExpireBucket, key, []byte{})
+			}
+		}
+
+		return nil
+	})
+	if nil != err {
+		Log.Error("db gc error:%v", err)
+	}
+
+	return leads, lates
+}
+
+// index entries of bucket, as migration
+func dbExpireIndexBucket(tx MetaTx, bucket []byte) error {
+	keys := [][]byte{}
+
+	err := tx.ForEach(bucket, nil, func(k, v []byte) error {
+		entry := &DbEntry{}
+		if nil == entry.FromBinary(v) && !entry.pinned {
+			keys = append(keys, dbExpireKey(entry))
+		}
+
+		return nil
+	})
+	if nil != err {
+		return err
+	}
+
+	// NOT put @ForEach
+	for _, k := range keys {
+		if err = tx.Put(dbExpireBucket, k, []byte{}); nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// signature of lives
+func dbExpireLive() uint32 {
+	h := fnv.New32a()
+
+	fmt.Fprintf(h, "%d", conf.Live)
+	for _, ns := range conf.Namespaces {
+		fmt.Fprintf(h, ",%d:%d", ns.ID, conf.live(ns.ID))
+	}
+
+	return h.Sum32()
+}
+
+// rebuild expiry index if live changed
+func dbExpireCheck() error {
+	var live uint32
+
+	db.View(func(tx MetaTx) error {
+		live = dbMetaGet(tx, dbMetaLive)
+
+		return nil
+	})
+
+	if live == dbExpireLive() {
+		return nil
+	}
+
+	Log.Info("db expire index rebuild begin")
+
+	// clear it
+	for {
+		keys := [][]byte{}
+
+		db.ForEach(dbExpireBucket, nil, func(k, v []byte) error {
+			keys = append(keys, append([]byte{}, k...))
+			if len(keys) >= dbGcBatch {
+				return ErrStop
+			}
+
+			return nil
+		})
+
+		if 0 == len(keys) {
+			break
+		}
+
+		err := db.Update(func(tx MetaTx) error {
+			for _, k := range keys {
+				if err := tx.Delete(dbExpireBucket, k); nil != err {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if nil != err {
+			return err
+		}
+	}
+
+	for i := 0; i <= 0xffff; i += dbMigrateBatch {
+		err := db.Update(func(tx MetaTx) error {
+			for j := i; j < i+dbMigrateBatch && j <= 0xffff; j++ {
+				if err := dbExpireIndexBucket(tx, dbBucket(Bkdr(j))); nil != err {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if nil != err {
+			return err
+		}
+	}
+
+	Log.Info("db expire index rebuild end")
+
+	return db.Update(func(tx MetaTx) error {
+		return dbMetaSet(tx, dbMetaLive, dbExpireLive())
+	})
+}
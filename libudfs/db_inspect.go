@@ -0,0 +1,175 @@
+package udfs
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+
+	. "asdf"
+
+	"github.com/boltdb/bolt"
+)
+
+// the running broker hold udfs.db(locked), NOT wait it long
+// the live listing is by broker, see dbList
+const dbToolTimeout = 1 * time.Second
+
+// open udfs.db read only, for tools
+// filename is Empty: Conf.DbFileName
+func dbOpenReadOnly(filename string) (*BoltStore, error) {
+	if Empty == filename {
+		initBase()
+
+		filename = conf.DbFileName.Abs().String()
+	}
+
+	bdb, err := bolt.Open(filename, 0644, &bolt.Options{
+		ReadOnly: true,
+		Timeout:  dbToolTimeout,
+	})
+	if nil != err {
+		Log.Error("open db:%s error:%v", filename, err)
+
+		return nil, err
+	}
+
+	return &BoltStore{
+		db: bdb,
+	}, nil
+}
+
+// listing of udfs.db, for tools
+// filename is Empty: by the local broker(live), Conf.DbFileName if the broker NOT running
+// else: the dbfile, read only, without conf(etcd)
+func dbList(w io.Writer, filename string, list *ProtoList) error {
+	list.ProtoHeader = NewProtoHeader(cmdList, 0)
+
+	if Empty == filename {
+		initBase()
+		initAuth(roleEnd) // the tool's credential by env, NOT all the secrets
+
+		node := newNode(conf.Nodes[thisNodeID])
+		node.addr = conf.Nodes[thisNodeID].local()
+
+		err := dbListByBroker(w, node, list)
+		if nil == err || nil != list.cursor {
+			// NOT open db after some pages written
+			return err
+		}
+
+		Log.Info("list %s by broker error:%v, open db", list.name, err)
+	}
+
+	store, err := dbOpenReadOnly(filename)
+	if nil != err {
+		return err
+	}
+	defer store.Close()
+
+	return store.View(func(tx MetaTx) error {
+		return list.list(w, tx)
+	})
+}
+
+// the listing by broker, page by page
+func dbListByBroker(w io.Writer, node *Node, list *ProtoList) error {
+	for {
+		data, err := node.request(list)
+		if nil != err {
+			return err
+		} else if len(data) < SizeofInt32 {
+			return ErrTooShortBuffer
+		}
+
+		ncursor := int(Ntohl(data))
+		if len(data) < SizeofInt32+ncursor {
+			return ErrTooShortBuffer
+		} else if _, err = w.Write(data[SizeofInt32+ncursor:]); nil != err {
+			return err
+		} else if 0 == ncursor {
+			return nil
+		}
+
+		list.cursor = append([]byte{}, data[SizeofInt32:SizeofInt32+ncursor]...)
+	}
+}
+
+// reply a page of the listing, to tool
+// reply: ncursor(4) + cursor of next page + text of page
+func dbListReply(list *ProtoList) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	var next []byte
+
+	err := db.View(func(tx MetaTx) error {
+		var err error
+
+		next, err = list.page(buf, tx, list.cursor)
+
+		return err
+	})
+	if nil != err {
+		return nil, err
+	}
+
+	data := make([]byte, SizeofInt32+len(next)+buf.Len())
+	Htonl(data, uint32(len(next)))
+	copy(data[SizeofInt32:], next)
+	copy(data[SizeofInt32+len(next):], buf.Bytes())
+
+	return data, nil
+}
+
+// dump udfs.db
+// filename is Empty: by the local broker, see dbList
+func DbInspect(w io.Writer, filename string) error {
+	return dbList(w, filename, &ProtoList{
+		name: listDb,
+	})
+}
+
+// a page of entries from cursor, the schema and other buckets @the first page
+func dbInspectTx(w io.Writer, tx MetaTx, cursor []byte) ([]byte, error) {
+	if nil == cursor {
+		fmt.Fprintf(w, "schema:%d latest:%d migrating:%d\n",
+			dbMetaGet(tx, dbMetaSchema),
+			dbSchemaLatest(),
+			dbMetaGet(tx, dbMetaMigrating))
+
+		tx.Buckets(func(name []byte) error {
+			if !dbIsBucket(name) {
+				count := 0
+				tx.ForEach(name, nil, func(k, v []byte) error {
+					count++
+
+					return nil
+				})
+
+				fmt.Fprintf(w, "bucket:%s keys:%d\n", string(name), count)
+			}
+
+			return nil
+		})
+	}
+
+	return dbScanTx(tx, dbBuckets(tx), cursor, func(bucket, k, v []byte) (bool, error) {
+		entry := &DbEntry{}
+		if err := entry.FromBinary(v); nil != err {
+			fmt.Fprintf(w, "bucket:%s key:%s error:%v\n",
+				hex.EncodeToString(bucket),
+				hex.EncodeToString(k),
+				err)
+		} else {
+			version, _ := dbEntryVersionOf(v)
+
+			fmt.Fprintf(w, "bucket:%s v%d %s\n",
+				hex.EncodeToString(bucket),
+				version,
+				entry.String())
+		}
+
+		return true, nil
+	})
+}
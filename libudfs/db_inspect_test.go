@@ -0,0 +1,66 @@
+package udfs
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	. "asdf"
+)
+
+// the listing by pages, as the tool by broker
+func dbListTestPages(t *testing.T, list *ProtoList) (string, int) {
+	buf := &bytes.Buffer{}
+	pages := 0
+
+	for {
+		data, err := dbListReply(list)
+		if nil != err {
+			t.Fatalf("list %s page:%d error:%v", list.name, pages, err)
+		}
+		pages++
+
+		ncursor := int(Ntohl(data))
+		buf.Write(data[SizeofInt32+ncursor:])
+		if 0 == ncursor {
+			return buf.String(), pages
+		}
+
+		list.cursor = append([]byte{}, data[SizeofInt32:SizeofInt32+ncursor]...)
+	}
+}
+
+func TestDbListPages(t *testing.T) {
+	db = newMemStore()
+
+	count := 3*dbScanBatch + 1
+	for i := 0; i < count; i++ {
+		entry := &DbEntry{
+			bkdr: Bkdr(i),
+			time: NowTime32(),
+		}
+		entry.digest[0] = byte(i)
+		entry.digest[1] = byte(i >> 8)
+		entry.pinned = 0 == i%2
+
+		if err := dbPut(entry); nil != err {
+			t.Fatalf("put error:%v", err)
+		}
+	}
+
+	text, pages := dbListTestPages(t, &ProtoList{name: listDb})
+	if lines := strings.Count(text, " v"); count != lines || pages < 4 {
+		t.Fatalf("db listing entries:%d pages:%d", lines, pages)
+	} else if 1 != strings.Count(text, "schema:") {
+		t.Fatalf("db listing schema NOT once")
+	}
+
+	text, pages = dbListTestPages(t, &ProtoList{name: listPin})
+	pinned := (count + 1) / 2
+	if lines := strings.Count(text, "\n"); pinned+1 != lines || pages < 2 {
+		t.Fatalf("pin listing lines:%d pages:%d", lines, pages)
+	} else if !strings.HasSuffix(text, fmt.Sprintf("pinned:%d\n", pinned)) {
+		t.Fatalf("pin listing NOT end with count")
+	}
+}
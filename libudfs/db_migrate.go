@@ -0,0 +1,184 @@
+package udfs
+
+import (
+	"encoding/binary"
+	"errors"
+
+	. "asdf"
+)
+
+// udfs.db schema
+//
+// meta bucket:
+// schema, uint32, version of done migrations
+// migrating, uint32, next data bucket of the running migration
+//
+// migration run @initDb, ordered by version(index+1)
+// data buckets are migrated in batches, each batch in one tx with the progress
+// so it resume from the last batch after crash
+
+const dbMigrateBatch = 256 // data buckets per tx
+
+var ErrBadSchema = errors.New("bad db schema")
+
+var (
+	dbMetaBucket    = []byte("meta")
+	dbMetaSchema    = []byte("schema")
+	dbMetaMigrating = []byte("migrating")
+)
+
+type dbMigration struct {
+	name string
+	// migrate one data bucket, MUST be idempotent
+	bucket func(tx MetaTx, bucket []byte) error
+}
+
+// append only, NOT change the order
+var dbMigrations = []dbMigration{
+	{
+		// v1: DbEntry binary v0 ==> v1
+		name:   "entry-v1",
+		bucket: dbMigrateEntryV1,
+	},
+	{
+		// v2: usage of quota, see quota.go
+		name:   "quota-usage",
+		bucket: dbMigrateQuota,
+	},
+	{
+		// v3: lru index, see evict.go
+		name:   "lru-index",
+		bucket: dbMigrateLru,
+	},
+}
+
+func dbSchemaLatest() uint32 {
+	return uint32(len(dbMigrations))
+}
+
+func dbMigrateEntryV1(tx MetaTx, bucket []byte) error {
+	updates := map[string][]byte{}
+
+	err := tx.ForEach(bucket, nil, func(k, v []byte) error {
+		if version, err := dbEntryVersionOf(v); nil != err || dbEntryV0 != version {
+			// NOT v0, or broken
+			return nil
+		}
+
+		entry := &DbEntry{}
+		if err := entry.FromBinary(v); nil != err {
+			return err
+		}
+
+		bin, err := ToBinary(entry)
+		if nil != err {
+			return err
+		}
+		updates[string(k)] = bin
+
+		return nil
+	})
+	if nil != err {
+		return err
+	}
+
+	// NOT put @ForEach
+	for k, v := range updates {
+		if err = tx.Put(bucket, []byte(k), v); nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func dbMetaGet(tx MetaTx, key []byte) uint32 {
+	v := tx.Get(dbMetaBucket, key)
+	if len(v) < SizeofInt32 {
+		return 0
+	}
+
+	return binary.BigEndian.Uint32(v)
+}
+
+func dbMetaSet(tx MetaTx, key []byte, value uint32) error {
+	var v [SizeofInt32]byte
+	binary.BigEndian.PutUint32(v[:], value)
+
+	return tx.Put(dbMetaBucket, key, v[:])
+}
+
+func dbSchema() uint32 {
+	var schema uint32
+
+	db.View(func(tx MetaTx) error {
+		schema = dbMetaGet(tx, dbMetaSchema)
+
+		return nil
+	})
+
+	return schema
+}
+
+// run one batch of migration
+// return true if the migration done
+func dbMigrateBatchRun(version uint32, m *dbMigration) (bool, error) {
+	done := false
+
+	err := db.Update(func(tx MetaTx) error {
+		begin := dbMetaGet(tx, dbMetaMigrating)
+		end := begin + dbMigrateBatch
+
+		for i := begin; i < end && i <= 0xffff; i++ {
+			if err := m.bucket(tx, dbBucket(Bkdr(i))); nil != err {
+				Log.Error("db migrate %d:%s bucket:%d error:%v", version, m.name, i, err)
+
+				return err
+			}
+		}
+
+		if end > 0xffff {
+			done = true
+
+			if err := dbMetaSet(tx, dbMetaMigrating, 0); nil != err {
+				return err
+			}
+
+			return dbMetaSet(tx, dbMetaSchema, version)
+		}
+
+		return dbMetaSet(tx, dbMetaMigrating, end)
+	})
+
+	return done, err
+}
+
+func dbMigrate() error {
+	schema := dbSchema()
+	latest := dbSchemaLatest()
+
+	if schema > latest {
+		Log.Error("db schema:%d newer than this version:%d", schema, latest)
+
+		return ErrBadSchema
+	}
+
+	for version := schema + 1; version <= latest; version++ {
+		m := &dbMigrations[version-1]
+
+		Log.Info("db migrate %d:%s begin", version, m.name)
+
+		for {
+			done, err := dbMigrateBatchRun(version, m)
+			if nil != err {
+				return err
+			} else if done {
+				break
+			}
+		}
+
+		Log.Info("db migrate %d:%s end", version, m.name)
+	}
+
+	return nil
+}
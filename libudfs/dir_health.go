@@ -0,0 +1,178 @@
+package udfs
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	. "asdf"
+)
+
+// health of dirs
+//
+// io error(NOT not exist) of UdfsFile is counted @its dir, success reset it
+// FailErrors errors in a row, the dir is failed:
+// 1. NOT for new file
+// 2. its files are evacuated: entry moved to other dir, file pulled from peers
+//
+// failed is NOT saved, the dir is healthy after restart
+
+const (
+	dirHealthy  int32 = 0
+	dirDegraded int32 = 1 // has errors
+	dirFailed   int32 = 2
+
+	deftDirFailErrors = 5
+	deftDirProbe      = 30 // second
+
+	dirProbeName = ".udfs.probe"
+)
+
+type HealthConf struct {
+	FailErrors int `json:"failerrors"` // errors in a row to fail the dir
+	Probe      int `json:"probe"`      // second, probe dirs interval
+}
+
+func (me *HealthConf) setDefault() {
+	if 0 == me.FailErrors {
+		me.FailErrors = deftDirFailErrors
+	}
+
+	if 0 == me.Probe {
+		me.Probe = deftDirProbe
+	}
+}
+
+type dirState struct {
+	errors int32
+	state  int32
+}
+
+var dirStates []*dirState
+
+func newDirStates() []*dirState {
+	states := make([]*dirState, len(dbConf.dirs()))
+
+	for i := range states {
+		states[i] = &dirState{}
+	}
+
+	return states
+}
+
+func dirIsFailed(idir int) bool {
+	return idir < len(dirStates) && dirFailed == atomic.LoadInt32(&dirStates[idir].state)
+}
+
+// report result of io @dir
+func dirReport(idir int, err error) {
+	if idir >= len(dirStates) {
+		return
+	}
+	st := dirStates[idir]
+
+	if nil == err || os.IsNotExist(err) {
+		if 0 != atomic.LoadInt32(&st.errors) {
+			atomic.StoreInt32(&st.errors, 0)
+			atomic.CompareAndSwapInt32(&st.state, dirDegraded, dirHealthy)
+		}
+
+		return
+	}
+
+	errors := atomic.AddInt32(&st.errors, 1)
+	if errors < int32(conf.Health.FailErrors) {
+		atomic.CompareAndSwapInt32(&st.state, dirHealthy, dirDegraded)
+	} else if atomic.CompareAndSwapInt32(&st.state, dirDegraded, dirFailed) ||
+		atomic.CompareAndSwapInt32(&st.state, dirHealthy, dirFailed) {
+		Log.Error("dir:%s failed, errors:%d last error:%v", dbConf.dirs()[idir], errors, err)
+
+		go dirEvacuate(idir)
+	}
+}
+
+// write/read/remove probe file @dirs
+func dirProbe() {
+	chTick := time.Tick(time.Duration(conf.Health.Probe) * time.Second)
+
+	for {
+		select {
+		case <-chTick:
+			for idir, dir := range dbConf.dirs() {
+				if Empty == dir || dirIsFailed(idir) {
+					continue
+				}
+
+				file := UdfsFile{
+					name: FileName(filepath.Join(dir, dirProbeName)),
+					idir: idir,
+				}
+
+				if nil == file.Save([]byte(dir)) {
+					if _, err := file.Load(); nil == err {
+						file.Delete()
+					}
+				}
+			}
+		}
+	}
+}
+
+// move entries @failed dir to other dirs, and pull files from peers
+func dirEvacuate(idir int) {
+	var checkpoint []byte
+	count, pulled := 0, 0
+
+	Log.Info("dir:%s evacuate start", dbConf.dirs()[idir])
+
+	for {
+		entries, next := dbScanEntries(checkpoint, func(entry *DbEntry) bool {
+			return int(entry.idir) == idir
+		})
+
+		for _, entry := range entries {
+			count++
+
+			to, err := dbConf.idir(entry.bkdr, entry.ns)
+			if nil != err {
+				// all dirs failed, keep them
+				Log.Error("dir:%s evacuate stopped, error:%v", dbConf.dirs()[idir], err)
+
+				return
+			}
+
+			moved := *entry
+			moved.idir = to
+			moved.loc = VolumeLoc{}
+
+			if err := dbMoveEntry(entry, &moved); nil != err {
+				continue
+			}
+
+			// missing file is repaired by reconciliation, if pull failed
+			if err := ep.pullGroup(entry.bkdr, entry.digest[:]); nil != err {
+				Log.Error("dir evacuate %s pull error:%v", entry.String(), err)
+			} else {
+				pulled++
+			}
+
+			diskMigrateWait(int(entry.size))
+		}
+
+		if string(next) == string(checkpoint) {
+			break
+		}
+		checkpoint = next
+	}
+
+	Log.Info("dir:%s evacuate done, entries:%d pulled:%d", dbConf.dirs()[idir], count, pulled)
+}
+
+func initDirHealth(role Role) {
+	if role == roleBroker {
+		dirStates = newDirStates()
+
+		go dirProbe()
+	}
+}
@@ -0,0 +1,142 @@
+package udfs
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+	"time"
+
+	. "asdf"
+)
+
+// select dir of new file
+//
+// hash: bkdr % len(dirs), the default
+// weight: Conf.DirWeights
+// free: free space of dirs
+//
+// weight/free is weighted hash of bkdr, the same bkdr same dir if weights not changed
+// the selected idir is saved @DbEntry, lookup NOT depend on it
+
+const (
+	dirSelectHash   = "hash"
+	dirSelectWeight = "weight"
+	dirSelectFree   = "free"
+
+	dirFreeUnit    = 1 << 30 // GB
+	dirFreeRefresh = 10 * time.Second
+)
+
+var ErrNoDir = errors.New("no dir for new file")
+
+func checkDirSelect(dirSelect string, weights []int, dirs int) int {
+	switch dirSelect {
+	case dirSelectHash, dirSelectFree, Empty:
+	case dirSelectWeight:
+		if len(weights) != dirs {
+			Log.Error("dir weights count:%d != dirs count:%d", len(weights), dirs)
+
+			return StdErrError
+		}
+
+		for _, w := range weights {
+			if w < 0 {
+				Log.Error("bad dir weight:%d", w)
+
+				return StdErrError
+			}
+		}
+	default:
+		Log.Error("bad dir select:%s", dirSelect)
+
+		return StdErrError
+	}
+
+	return 0
+}
+
+// free space cache of dirs
+type dirFree struct {
+	lock  sync.Mutex
+	time  time.Time
+	dirs  []string
+	frees []uint64
+}
+
+var dirFrees = &dirFree{}
+
+func (me *dirFree) get(dirs []string, idir int) uint64 {
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	if len(me.dirs) != len(dirs) || time.Since(me.time) > dirFreeRefresh {
+		me.refresh(dirs)
+	}
+
+	return me.frees[idir]
+}
+
+func (me *dirFree) refresh(dirs []string) {
+	me.time = time.Now()
+	me.dirs = dirs
+	me.frees = make([]uint64, len(dirs))
+
+	for i, dir := range dirs {
+		st := syscall.Statfs_t{}
+		if err := syscall.Statfs(dir, &st); nil != err {
+			Log.Error("statfs dir:%s error:%v", dir, err)
+
+			continue
+		}
+
+		me.frees[i] = uint64(st.Bavail) * uint64(st.Bsize)
+	}
+}
+
+// weight of dirs[idir], dirs is the snapshot of dbConf.dirs()
+func (me *DbConf) weight(dirs []string, idir int) uint64 {
+	switch conf.DirSelect {
+	case dirSelectWeight:
+		if i := conf.findDir(dirs[idir]); InvalidID != i {
+			return uint64(conf.DirWeights[i])
+		}
+
+		// dir NOT in conf, NOT for new file
+		return 0
+	case dirSelectFree:
+		return dirFrees.get(dirs, idir) / dirFreeUnit
+	default:
+		return 1
+	}
+}
+
+// weighted hash of bkdr in candidates(idir of dirs)
+// ErrNoDir if no candidate, all failed/removed
+func (me *DbConf) selectDir(dirs []string, bkdr Bkdr, candidates []int) (byte, error) {
+	if 0 == len(candidates) {
+		return 0, ErrNoDir
+	}
+
+	weights := make([]uint64, len(candidates))
+	total := uint64(0)
+
+	for i, idir := range candidates {
+		weights[i] = me.weight(dirs, idir)
+		total += weights[i]
+	}
+
+	if 0 == total {
+		// all full or zero weight, fall back to hash
+		return byte(candidates[int(bkdr)%len(candidates)]), nil
+	}
+
+	v := uint64(bkdr) % total
+	for i, w := range weights {
+		if v < w {
+			return byte(candidates[i]), nil
+		}
+		v -= w
+	}
+
+	return byte(candidates[len(candidates)-1]), nil
+}
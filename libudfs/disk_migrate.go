@@ -0,0 +1,315 @@
+package udfs
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	. "asdf"
+)
+
+// online disk add/remove
+//
+// idir of DbEntry is the index of DbConf.Dirs, and NOT changed when dirs changed
+// 1. new dirs are appended to DbConf.Dirs
+// 2. removed dirs are kept until their files moved, NOT for new file
+// 3. serve from both, the file is @its entry's dir
+// 4. when moved, the removed dirs are holes(Empty) and DbConf is saved
+//
+// the migrating dirs and checkpoint are @db, resume after restart
+// the moved file is deleted after diskMigrateGrace, the pulling maybe read it
+
+const (
+	deftMigrateRate  = 200 // files per second
+	diskMigrateGrace = time.Minute
+)
+
+var (
+	dbMigrateDirBucket  = []byte("dirmigrate")
+	dbMigrateDirs       = []byte("dirs")
+	dbMigrateCheckpoint = []byte("checkpoint")
+)
+
+var diskMigrating bool
+
+// the moved files waiting delete
+var diskMigrateDeletes sync.WaitGroup
+
+type MigrateConf struct {
+	Rate      int `json:"rate"`      // files per second
+	Bandwidth int `json:"bandwidth"` // MB per second, 0 is unlimited
+}
+
+func (me *MigrateConf) setDefault() {
+	if 0 == me.Rate {
+		me.Rate = deftMigrateRate
+	}
+}
+
+// dir is NOT in conf
+func (me *DbConf) removed(idir int) bool {
+	dir := me.dirs()[idir]
+
+	return Empty == dir || InvalidID == conf.findDir(dir)
+}
+
+// dir is in conf and NOT failed, for new file
+func (me *DbConf) active(idir int) bool {
+	return !me.removed(idir) && !dirIsFailed(idir)
+}
+
+// dirs of conf appended
+func (me *DbConf) union() []string {
+	dirs := append([]string{}, me.dirs()...)
+
+	for _, dir := range conf.Dirs {
+		found := false
+		for _, v := range dirs {
+			if dir == v {
+				found = true
+
+				break
+			}
+		}
+
+		if !found {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
+
+// remove dirs NOT in conf, keep index of others
+func (me *DbConf) holed() []string {
+	dirs := append([]string{}, me.dirs()...)
+
+	for i := range dirs {
+		if me.removed(i) {
+			dirs[i] = Empty
+		}
+	}
+
+	for len(dirs) > 0 && Empty == dirs[len(dirs)-1] {
+		dirs = dirs[:len(dirs)-1]
+	}
+
+	return dirs
+}
+
+func dirsEq(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// load migrating dirs, or start new migration
+// return true if migrating
+func diskMigrateInit() (bool, error) {
+	pending := false
+
+	err := db.Update(func(tx MetaTx) error {
+		if v := tx.Get(dbMigrateDirBucket, dbMigrateDirs); nil != v {
+			dirs := []string{}
+			if err := json.Unmarshal(v, &dirs); nil != err {
+				return err
+			}
+
+			// migration of last run
+			dbConf.setDirs(dirs)
+			pending = true
+		}
+
+		dirs := dbConf.union()
+		if dirsEq(dirs, dbConf.dirs()) && (pending || dbConf.eq()) {
+			// resume, or nothing changed
+			return nil
+		}
+		dbConf.setDirs(dirs)
+		pending = true
+
+		bin, err := json.Marshal(dirs)
+		if nil != err {
+			return err
+		} else if err = tx.Put(dbMigrateDirBucket, dbMigrateDirs, bin); nil != err {
+			return err
+		}
+
+		return tx.Delete(dbMigrateDirBucket, dbMigrateCheckpoint)
+	})
+
+	return pending, err
+}
+
+// save DbConf, and clear migrating
+func diskMigrateFinish() error {
+	// the old dirs are empty
+	diskMigrateDeletes.Wait()
+
+	dbConf.setDirs(dbConf.holed())
+
+	if err := conf.DbConfName.Abs().SaveJson(&DbConf{Dirs: dbConf.dirs()}); nil != err {
+		Log.Error("save db conf error:%v", err)
+
+		return err
+	}
+
+	err := db.Update(func(tx MetaTx) error {
+		tx.Delete(dbMigrateDirBucket, dbMigrateCheckpoint)
+
+		return tx.Delete(dbMigrateDirBucket, dbMigrateDirs)
+	})
+	if nil != err {
+		return err
+	}
+
+	diskMigrating = false
+
+	Log.Info("disk migrate finished, dirs:%v", dbConf.dirs())
+
+	return nil
+}
+
+// copy file to new dir, update entry, then delete the old file after diskMigrateGrace
+// crash between them, the old file is orphan, cleaned by reconciliation
+func diskMigrateEntry(entry *DbEntry) (int, error) {
+	idir, err := dbConf.idir(entry.bkdr, entry.ns)
+	if nil != err {
+		return 0, err
+	}
+
+	moved := *entry
+	moved.idir = idir
+	moved.loc = VolumeLoc{}
+
+	from := entry.File()
+	to := moved.File()
+
+	local := blobIsLocal()
+	size := 0
+
+	if local && blobStore.Exist(from) {
+		content, err := blobStore.Load(from)
+		if nil != err {
+			return 0, err
+		} else if err = blobStore.Save(to, content); nil != err {
+			return 0, err
+		}
+		blobStore.Touch(to, entry.time)
+
+		size = len(content)
+	}
+
+	err = dbMoveEntry(entry, &moved)
+	if ErrNoExist == err {
+		// deleted when moving
+		if local {
+			blobStore.Delete(to)
+		}
+	} else if nil != err {
+		return 0, err
+	}
+
+	if local {
+		diskMigrateDelete(from)
+	}
+
+	return size, nil
+}
+
+// delete the moved file after diskMigrateGrace
+// the pull got the old entry maybe read it, or the consumer @local open it
+func diskMigrateDelete(file UdfsFile) {
+	diskMigrateDeletes.Add(1)
+
+	time.AfterFunc(diskMigrateGrace, func() {
+		defer diskMigrateDeletes.Done()
+
+		blobStore.Delete(file)
+	})
+}
+
+// sleep for rate limit
+func diskMigrateWait(size int) {
+	wait := time.Second / time.Duration(conf.Migrate.Rate)
+
+	if conf.Migrate.Bandwidth > 0 {
+		bw := time.Duration(size) * time.Second / time.Duration(conf.Migrate.Bandwidth<<20)
+		if bw > wait {
+			wait = bw
+		}
+	}
+
+	time.Sleep(wait)
+}
+
+// one pass from checkpoint, return count of moved and failed
+func diskMigratePass() (int, int) {
+	var checkpoint []byte
+	moved, failed := 0, 0
+
+	db.View(func(tx MetaTx) error {
+		if v := tx.Get(dbMigrateDirBucket, dbMigrateCheckpoint); nil != v {
+			checkpoint = append([]byte{}, v...)
+		}
+
+		return nil
+	})
+
+	for {
+		entries, next := dbScanEntries(checkpoint, func(entry *DbEntry) bool {
+			return int(entry.idir) < len(dbConf.dirs()) && dbConf.removed(int(entry.idir))
+		})
+
+		for _, entry := range entries {
+			size, err := diskMigrateEntry(entry)
+			if nil != err {
+				// keep it @old dir, try next pass
+				Log.Error("disk migrate %s error:%v", entry.String(), err)
+
+				failed++
+			} else {
+				moved++
+			}
+
+			diskMigrateWait(size)
+		}
+
+		if string(next) == string(checkpoint) {
+			// the end, next pass from begin
+			db.Delete(dbMigrateDirBucket, dbMigrateCheckpoint)
+
+			return moved, failed
+		}
+		checkpoint = next
+
+		db.Put(dbMigrateDirBucket, dbMigrateCheckpoint, checkpoint)
+	}
+}
+
+// broker, background
+func diskMigrate() {
+	Log.Info("disk migrate start, dirs:%v", dbConf.dirs())
+
+	// until nothing to move
+	// entry updated @removed dir after its pass is moved by next pass
+	for {
+		moved, failed := diskMigratePass()
+		if 0 == moved && 0 == failed {
+			break
+		} else if 0 == moved {
+			// all failed, maybe disk error
+			time.Sleep(time.Minute)
+		}
+	}
+
+	diskMigrateFinish()
+}
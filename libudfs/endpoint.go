@@ -1,8 +1,6 @@
 package udfs
 
 import (
-	"encoding/binary"
-	"os"
 	"time"
 
 	. "asdf"
@@ -16,11 +14,14 @@ func initEndPoint(role Role) {
 
 func newEndPoint(role Role) *EndPoint {
 	count := len(conf.Nodes)
-	more := conf.Replication - 1
+	// leader slots, the same as before namespaces, NOT remap the leaders
+	leaders := count + conf.Replication - 1
+	more := leaders - count + maxReplication - 1
 
 	ep := &EndPoint{
-		nodes: make([]*Node, count+more),
-		role:  role,
+		nodes:   make([]*Node, count+more),
+		leaders: leaders,
+		role:    role,
 	}
 
 	for i := 0; i < count; i++ {
@@ -28,13 +29,15 @@ func newEndPoint(role Role) *EndPoint {
 	}
 
 	for i := 0; i < more; i++ {
-		ep.nodes[count+i] = ep.nodes[i]
+		ep.nodes[count+i] = ep.nodes[i%count]
 	}
 
 	if role != roleConsumer {
-		listener, err := ListenTcp(conf.Port, "0.0.0.0")
+		self := conf.Nodes[thisNodeID]
+
+		listener, err := ListenTcp(self.Port, self.Bind)
 		if nil != err {
-			Log.Error("listen port:%d error:%v", conf.Port, err)
+			Log.Error("listen %s:%d error:%v", self.Bind, self.Port, err)
 
 			panic(StdErrListen)
 		}
@@ -46,12 +49,13 @@ func newEndPoint(role Role) *EndPoint {
 
 type EndPoint struct {
 	nodes    []*Node
+	leaders  int // count of leader slots, nodes + Conf.Replication - 1
 	listener *TcpListener
 	role     Role
 }
 
 func (me *EndPoint) inode(bkdr Bkdr) int {
-	return int(bkdr) % len(me.nodes)
+	return int(bkdr) % me.leaders
 }
 
 func (me *EndPoint) self() *Node {
@@ -62,82 +66,225 @@ func (me *EndPoint) leader(bkdr Bkdr) *Node {
 	return me.nodes[me.inode(bkdr)]
 }
 
-func (me *EndPoint) group(bkdr Bkdr) []*Node {
+func (me *EndPoint) group(bkdr Bkdr, ns byte) []*Node {
 	// count: 5
-	// Replication: 2
-	// nodes: 5+2-1=6
+	// Replication: 2, namespace's: 3
+	// leaders: 5+2-1=6
+	// nodes: 6+3-1=8
 
-	// leader: 4
-	// group: [4:5]
-	// flower:[5:5]
+	// leader: 5
+	// group: [5:8]
+	// flower:[6:8]
 	iLeader := me.inode(bkdr)
 
-	return me.nodes[iLeader : iLeader+conf.Replication-1]
+	return me.nodes[iLeader : iLeader+conf.replication(ns)]
 }
 
-func (me *EndPoint) followers(bkdr Bkdr) []*Node {
-	group := me.group(bkdr)
+func (me *EndPoint) followers(bkdr Bkdr, ns byte) []*Node {
+	group := me.group(bkdr, ns)
 
 	return group[1:]
 }
 
-func (me *EndPoint) push(bkdr Bkdr, time Time32, digest, content []byte) error {
-	file := dbConf.File(bkdr, digest)
+// time is 0: now
+// publisher: from the authenticated publisher, checked quota
+// else: the replica of group/remote, NOT checked quota
+func (me *EndPoint) push(bkdr Bkdr, time Time32, digest, content []byte, attr *FileAttr, publisher bool) error {
+	entry, exist := dbEntry(bkdr, digest, attr.ns)
+	quota := !exist && publisher
+
+	if exist && entry.time > time && 0 != time {
+		// newer @local, keep it
+		return nil
+	} else if !exist && dbTombed(bkdr, digest, time) {
+		// deleted after it
+		return nil
+	} else if !exist {
+		if _, err := dbConf.idir(bkdr, attr.ns); nil != err {
+			return err
+		} else if quota {
+			// fast reject, before saving the file
+			if err = quotaCheck(attr); nil != err {
+				return err
+			}
+		}
+	}
+	entry.time = newtime32(time)
+
+	file := entry.File()
+	if !exist {
+		entry.FileAttr = *attr
+		entry.size = uint64(len(content))
+	} else if 0 != attr.ttl || 0 != attr.expire {
+		// new expiry, re-keyed @dbPutTx
+		entry.ttl = attr.ttl
+		entry.expire = attr.expire
+	}
 
-	if !dbExist(bkdr, digest) {
-		file.Save(content)
+	if !exist || !blobStore.Exist(file) {
+		// commit db entry after the file is durable
+		if err := blobStore.Save(file, content); nil != err {
+			return err
+		}
 	}
-	file.Touch(time)
+	blobStore.Touch(file, entry.time)
+
+	if !quota {
+		if err := dbPut(entry); nil != err {
+			return err
+		}
+	} else if err := dbPutQuota(entry); nil != err {
+		if ErrQuota == err {
+			// pushed by others after the fast check
+			blobStore.Delete(file)
+		}
 
-	dbAdd(bkdr, digest, time)
+		return err
+	}
 
 	if me.self() == me.leader(bkdr) {
 		// leader should re-do it to follers
-		return me.pushFollowers(bkdr, time, digest, content)
+		// the same time @group, for LWW and tombstone
+		return me.pushFollowers(bkdr, entry.time, digest, content, &entry.FileAttr)
 	} else {
 		return nil
 	}
 }
 
-func (me *EndPoint) pushFollowers(bkdr Bkdr, time Time32, digest, content []byte) error {
+// ok if any follower ok
+func (me *EndPoint) pushFollowers(bkdr Bkdr, time Time32, digest, content []byte, attr *FileAttr) error {
 	var err error
 
-	followers := me.followers(bkdr)
+	followers := me.followers(bkdr, attr.ns)
+	ok := 0 == len(followers)
 
 	for _, node := range followers {
-		err := node.push(bkdr, time, digest, content)
-		if nil == err {
-			return nil
+		if e := node.push(bkdr, time, digest, content, attr); nil != e {
+			err = e
+		} else {
+			ok = true
 		}
 	}
 
-	return err
+	if ok {
+		return nil
+	} else {
+		return err
+	}
 }
 
-func (me *EndPoint) del(bkdr Bkdr, digest []byte) error {
-	file := dbConf.File(bkdr, digest)
+// time is 0: del anyway
+// else: NOT del if the file is newer than time
+// force: NOT trash, the file(and the trashed) deleted now
+// return count of removed copies
+func (me *EndPoint) del(bkdr Bkdr, digest []byte, time Time32, force bool) (int, error) {
+	count := 0
+
+	entry, exist := dbEntry(bkdr, digest, nsDefault)
+	if exist && entry.time > time && 0 != time {
+		// newer @local, keep it
+		return 0, nil
+	}
+	// the same del time @leader and followers, like push
+	time = newtime32(time)
+
+	if exist {
+		if force || !trashEnabled() {
+			// else kept @trash, purged by gc
+			blobStore.Delete(entry.File())
+		}
+
+		count++
+	}
 
-	if dbExist(bkdr, digest) {
-		file.Delete()
+	if force {
+		if trashed := dbTrashGet(bkdr, digest); nil != trashed && dbConf.has(trashed.idir) {
+			blobStore.Delete(trashed.File())
+		}
 	}
 
-	dbDel(bkdr, digest)
+	// keep tombstone, NOT write it back by the older push/repair
+	dbTombPut(bkdr, digest, time, force)
 
 	if me.self() == me.leader(bkdr) {
 		// leader should re-do it to follers
-		return me.delFollowers(bkdr, digest)
+		n, err := me.delFollowers(bkdr, digest, time, entry.ns, force)
+
+		return count + n, err
 	} else {
-		return nil
+		return count, nil
 	}
 }
 
-func (me *EndPoint) delFollowers(bkdr Bkdr, digest []byte) error {
+// ok if any follower ok
+// return count of removed copies @followers
+func (me *EndPoint) delFollowers(bkdr Bkdr, digest []byte, time Time32, ns byte, force bool) (int, error) {
 	var err error
 
-	followers := me.followers(bkdr)
+	followers := me.followers(bkdr, ns)
+	ok := 0 == len(followers)
+	count := 0
 
 	for _, node := range followers {
-		err = node.del(bkdr, digest)
+		if n, e := node.del(bkdr, digest, time, force); nil != e {
+			err = e
+		} else {
+			ok = true
+			count += n
+		}
+	}
+
+	if ok {
+		return count, nil
+	} else {
+		return count, err
+	}
+}
+
+func (me *EndPoint) pull(bkdr Bkdr, digest []byte) (*DbEntry, error) {
+	entry, exist := dbEntry(bkdr, digest, nsDefault)
+	if exist && dirIsFailed(int(entry.idir)) {
+		// NOT evacuated yet, move it now
+		if idir, err := dbConf.idir(bkdr, entry.ns); nil == err {
+			moved := *entry
+			moved.idir = idir
+			moved.loc = VolumeLoc{}
+
+			dbMoveEntry(entry, &moved)
+			entry = &moved
+		}
+	}
+	file := entry.File()
+
+	if exist && blobStore.Exist(file) {
+		// file exist @local
+		if accessTracked() {
+			accesses.add(entry.bkdr, entry.digest[:])
+		}
+
+		return entry, nil
+	} else if err := me.pullGroup(bkdr, digest); nil != err {
+		return nil, err
+	} else {
+		return dbGet(bkdr, digest)
+	}
+}
+
+// self maybe not in group, pull from the whole group
+func (me *EndPoint) pullGroup(bkdr Bkdr, digest []byte) error {
+	err := ErrNoExist
+
+	// ns unknow, try the max group
+	iLeader := me.inode(bkdr)
+	group := me.nodes[iLeader : iLeader+conf.replicationMax()]
+
+	for _, node := range group {
+		if node == me.self() {
+			continue
+		}
+
+		// pull file from node, and save local
+		_, err = node.pull(bkdr, digest)
 		if nil == err {
 			return nil
 		}
@@ -146,56 +293,158 @@ func (me *EndPoint) delFollowers(bkdr Bkdr, digest []byte) error {
 	return err
 }
 
-func (me *EndPoint) pull(bkdr Bkdr, digest []byte) error {
-	file := dbConf.File(bkdr, digest)
+// time is 0: now
+func (me *EndPoint) touch(bkdr Bkdr, digest []byte, time Time32) error {
+	time = newtime32(time)
 
-	if dbExist(bkdr, digest) && file.Exist() {
-		// file exist @local
+	entry, exist := dbEntry(bkdr, digest, nsDefault)
+	if exist && entry.time > time {
+		// newer @local, keep it
+		return nil
+	} else if !exist && dbTombed(bkdr, digest, time) {
+		// deleted after it
 		return nil
+	}
+	entry.time = time
+
+	if err := dbPut(entry); nil != err {
+		return err
+	}
+
+	blobStore.Touch(entry.File(), time)
+
+	if me.self() == me.leader(bkdr) {
+		// leader should re-do it to follers
+		return me.touchFollowers(bkdr, digest, time, entry.ns)
 	} else {
-		return me.pullFollowers(bkdr, digest)
+		return nil
+	}
+}
+
+// touch the pulled @group, by the leader
+// if the leader failed, touch followers
+func (me *EndPoint) touchPulled(bkdr Bkdr, digest []byte, time Time32, ns byte) error {
+	if me.self() == me.leader(bkdr) {
+		return me.touch(bkdr, digest, time)
+	} else if err := me.leader(bkdr).touch(bkdr, digest, time); nil == err {
+		return nil
 	}
+
+	return me.touchFollowers(bkdr, digest, time, ns)
 }
 
-func (me *EndPoint) pullFollowers(bkdr Bkdr, digest []byte) error {
+// ok if any follower ok
+func (me *EndPoint) touchFollowers(bkdr Bkdr, digest []byte, time Time32, ns byte) error {
 	var err error
 
-	followers := me.followers(bkdr)
+	followers := me.followers(bkdr, ns)
+	ok := 0 == len(followers)
 
 	for _, node := range followers {
-		// pull file from node, and save local
-		err = node.pull(bkdr, digest)
-		if nil == err {
-			return nil
+		if e := node.touch(bkdr, digest, time); nil != e {
+			err = e
+		} else {
+			ok = true
 		}
 	}
 
-	return err
+	if ok {
+		return nil
+	} else {
+		return err
+	}
 }
 
-func (me *EndPoint) touch(bkdr Bkdr, digest []byte) error {
-	time := NowTime32()
-	file := dbConf.File(bkdr, digest)
+// pin/unpin the file, pinned is NOT gc
+func (me *EndPoint) pin(bkdr Bkdr, digest []byte, pin bool) error {
+	entry, exist := dbEntry(bkdr, digest, nsDefault)
+	if !exist {
+		return ErrNoExist
+	}
+
+	if entry.pinned != pin {
+		entry.pinned = pin
 
-	file.Touch(time)
-	dbAdd(bkdr, digest, time)
+		if err := dbPut(entry); nil != err {
+			return err
+		}
+	}
 
 	if me.self() == me.leader(bkdr) {
 		// leader should re-do it to follers
-		return me.touchFollowers(bkdr, digest)
+		return me.pinFollowers(bkdr, digest, pin, entry.ns)
 	} else {
 		return nil
 	}
 }
 
-func (me *EndPoint) touchFollowers(bkdr Bkdr, digest []byte) error {
+// ok if any follower ok
+func (me *EndPoint) pinFollowers(bkdr Bkdr, digest []byte, pin bool, ns byte) error {
 	var err error
 
-	followers := me.followers(bkdr)
+	followers := me.followers(bkdr, ns)
+	ok := 0 == len(followers)
 
 	for _, node := range followers {
-		err = node.touch(bkdr, digest)
-		if nil == err {
+		if e := node.pin(bkdr, digest, pin); nil != e {
+			err = e
+		} else {
+			ok = true
+		}
+	}
+
+	if ok {
+		return nil
+	} else {
+		return err
+	}
+}
+
+// the file exist @local, and NOT evicting
+func (me *EndPoint) exist(bkdr Bkdr, digest []byte) error {
+	bkdr = newbkdr(bkdr, digest)
+
+	if evictings.has(bkdr, digest) {
+		return ErrNoExist
+	}
+
+	entry, exist := dbEntry(bkdr, digest, nsDefault)
+	if !exist || !blobStore.Exist(entry.File()) {
+		return ErrNoExist
+	}
+
+	return nil
+}
+
+// the file exist @other node of group
+func (me *EndPoint) existOthers(bkdr Bkdr, digest []byte, ns byte) bool {
+	for _, node := range me.group(bkdr, ns) {
+		if node != me.self() && nil == node.exist(bkdr, digest) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// restore the trashed file
+func (me *EndPoint) undel(bkdr Bkdr, digest []byte) error {
+	entry, err := dbTrashRestore(bkdr, digest)
+	if nil == err && nil != entry {
+		Log.Info("undel %s", entry.String())
+	}
+
+	if me.self() == me.leader(bkdr) {
+		// leader should re-do it to follers
+		// ok if restored @any
+		ns := nsDefault
+		if nil != entry {
+			ns = entry.ns
+		} else if e, _ := dbGet(bkdr, digest); nil != e {
+			ns = e.ns
+		}
+
+		if e := me.undelFollowers(bkdr, digest, ns); nil == e {
 			return nil
 		}
 	}
@@ -203,6 +452,28 @@ func (me *EndPoint) touchFollowers(bkdr Bkdr, digest []byte) error {
 	return err
 }
 
+// ok if any follower ok
+func (me *EndPoint) undelFollowers(bkdr Bkdr, digest []byte, ns byte) error {
+	var err error
+
+	followers := me.followers(bkdr, ns)
+	ok := 0 == len(followers)
+
+	for _, node := range followers {
+		if e := node.undel(bkdr, digest); nil != e {
+			err = e
+		} else {
+			ok = true
+		}
+	}
+
+	if ok {
+		return nil
+	} else {
+		return err
+	}
+}
+
 func (me *EndPoint) listen() {
 	for {
 		conn, err := me.listener.AcceptTCP()
@@ -219,60 +490,260 @@ func (me *EndPoint) listen() {
 // request handler
 //
 func (me *EndPoint) handle(stream *TcpStream) error {
-	var stderr = 1
+	bin, err := stream.Read()
+	if nil != err {
+		return err
+	}
 
-	hdr, msg, err := protoRead(stream, true)
+	hdr, msg, err := protoParse(bin, true)
 	if nil != err {
 		return err
 	}
 
+	// if nil, reply ok
+	var reply func() error
+
 	defer func() {
 		if nil != err {
-			replyError(stream, hdr.cmd, stderr, err.Error())
+			replyError(stream, hdr.cmd, errnoOf(err), err.Error())
+		} else if nil != reply {
+			reply()
 		} else {
 			replyOk(stream, hdr.cmd)
 		}
 	}()
 
+	// credential and role permission
+	if err = authCheck(hdr, bin); nil != err {
+		return err
+	}
+
+	if hdr.flag.Has(flagRemote) {
+		if data, ok := me.remoteForward(msg); ok {
+			if 0 != len(data) {
+				reply = func() error {
+					return replyData(stream, hdr.cmd, data)
+				}
+			}
+
+			return nil
+		}
+	}
+
 	switch hdr.cmd {
 	case cmdPush:
 		obj := msg.(*ProtoTransfer)
 
-		err = me.push(obj.bkdr, obj.time, obj.digest, obj.content)
+		client := conf.Auth.find(hdr.client)
+		publisher := conf.Auth.Enable && nil != client && rolePublisher == client.role
+		if publisher {
+			// owner for quota, NOT trust the attr and time from publisher
+			obj.FileAttr.client = client.ID
+			obj.time = 0
+		}
+
+		if err = obj.FileAttr.check(); nil == err {
+			err = me.push(obj.bkdr, obj.time, obj.digest, obj.content, &obj.FileAttr, publisher)
+		}
 	case cmdPull:
 		obj := msg.(*ProtoIdentify)
 
-		err = me.pull(obj.bkdr, obj.digest)
+		reply, err = me.pullReply(stream, hdr, obj)
 	case cmdDel:
 		obj := msg.(*ProtoIdentify)
 
-		err = me.del(obj.bkdr, obj.digest)
+		var count int
+
+		count, err = me.del(obj.bkdr, obj.digest, obj.time, hdr.flag.Has(flagForce))
+		reply = func() error {
+			data := make([]byte, SizeofInt32)
+			Htonl(data, uint32(count))
+
+			return replyData(stream, hdr.cmd, data)
+		}
 	case cmdTouch:
 		obj := msg.(*ProtoIdentify)
 
-		err = me.touch(obj.bkdr, obj.digest)
+		err = me.touch(obj.bkdr, obj.digest, obj.time)
+	case cmdPin:
+		obj := msg.(*ProtoIdentify)
+
+		err = me.pin(obj.bkdr, obj.digest, obj.pin)
+	case cmdUndel:
+		obj := msg.(*ProtoIdentify)
+
+		err = me.undel(obj.bkdr, obj.digest)
+	case cmdExist:
+		obj := msg.(*ProtoIdentify)
+
+		err = me.exist(obj.bkdr, obj.digest)
+	case cmdList:
+		obj := msg.(*ProtoList)
+
+		var data []byte
+
+		data, err = dbListReply(obj)
+		reply = func() error {
+			return replyData(stream, hdr.cmd, data)
+		}
+	}
+
+	if nil == err && nil != xrepl {
+		xrepl.log(hdr, msg)
 	}
 
 	return err
 }
 
+// the remote cluster NOT know our leaders, its xlog is sent to any node
+// non-leader forward it to the leader(and its followers)
+// handle it self if the leader failed
+// return the reply data, and forwarded or not
+func (me *EndPoint) remoteForward(msg IBinary) ([]byte, bool) {
+	var bkdr Bkdr
+
+	switch obj := msg.(type) {
+	case *ProtoTransfer:
+		bkdr = obj.bkdr
+	case *ProtoIdentify:
+		bkdr = obj.bkdr
+	default:
+		return nil, false
+	}
+
+	leader := me.leader(bkdr)
+	if me.self() == leader {
+		return nil, false
+	}
+
+	data, err := leader.request(msg.(IProto))
+	if nil != err {
+		Log.Info("remote forward to leader error:%v, handle it self", err)
+
+		return nil, false
+	}
+
+	return data, true
+}
+
+func (me *EndPoint) pullReply(stream *TcpStream, hdr *ProtoHeader, obj *ProtoIdentify) (func() error, error) {
+	entry, err := me.pull(obj.bkdr, obj.digest)
+	if nil != err {
+		return nil, err
+	}
+
+	file := entry.File()
+
+	if hdr.flag.Has(flagLocal) {
+		// consumer @local, just filename
+		filename, err := blobStore.Local(file)
+		if nil != err {
+			return nil, err
+		}
+
+		return func() error {
+			return replyLocal(stream, hdr.cmd, filename)
+		}, nil
+	}
+
+	content, err := blobStore.Load(file)
+	if nil != err {
+		return nil, err
+	}
+
+	return func() error {
+		return replyFile(stream, hdr.cmd, entry.time, entry.bkdr, obj.digest, content, &entry.FileAttr)
+	}, nil
+}
+
+// broker gc the expired by expiry index, see db_expire.go
+// the leader del it @group, followers wait the del
+// if the leader NOT del it after GcGrace, del it @local
+// Conf.GcDryRun: NOT gc, log what would be deleted hourly
+// the spool copies are cleaned hourly, if blob NOT local
 func (me *EndPoint) gc() {
-	var bucket [2]byte
-	var ticks uint64
+	chTick := time.Tick(time.Second)
+	chTomb := time.Tick(time.Hour)
 
-	// Day = 3600*24*60 = 86400 Second
-	// whole gc: 5*65536 = 327680 = 3.8 Day
-	chTick := time.Tick(5 * time.Second)
+	lead := func(bkdr Bkdr) bool {
+		return me.self() == me.leader(bkdr)
+	}
+
+	for {
+		select {
+		case <-chTick:
+			if conf.GcDryRun {
+				// NOT gc, see chTomb
+				continue
+			}
+
+			// until nothing expired
+			for {
+				leads, lates := dbGc(NowTime32(), conf.GcGrace, lead)
+
+				for _, e := range leads {
+					// del @local and followers
+					// NOT del the newer(touched) follower
+					if _, err := me.del(e.bkdr, e.digest[:], e.time, false); nil != err {
+						Log.Error("gc %s error:%v", e.String(), err)
+					}
+				}
+
+				for _, e := range lates {
+					dbRemove(e)
+				}
+
+				if len(leads)+len(lates) < dbGcBatch {
+					break
+				}
+			}
+
+			// purge trash, until nothing
+			// even if disabled now, the trashed before
+			for {
+				if dbTrashPurge(NowTime32()) < dbGcBatch {
+					break
+				}
+			}
+		case <-chTomb:
+			dbTombPrune()
+
+			if !blobIsLocal() {
+				if count := blobSpoolClean(time.Now()); count > 0 {
+					Log.Info("gc spool removed:%d", count)
+				}
+			}
+
+			if conf.GcDryRun {
+				gcDryRun()
+			}
+		}
+	}
+}
+
+// publisher has no file, its db is just a cache of pushed
+// forget the expired, NOT gc the cluster
+func (me *EndPoint) prune() {
+	chTick := time.Tick(time.Minute)
+
+	all := func(bkdr Bkdr) bool {
+		return true
+	}
 
 	for {
 		select {
 		case <-chTick:
-			binary.BigEndian.PutUint16(bucket[:], uint16(ticks))
-			ticks++
+			for {
+				leads, _ := dbGc(NowTime32(), 0, all)
+
+				for _, e := range leads {
+					dbDel(e.bkdr, e.digest[:])
+				}
 
-			dbGc(bucket[:], func(file UdfsFile) {
-				file.Delete()
-			})
+				if len(leads) < dbGcBatch {
+					break
+				}
+			}
 		}
 	}
 }
@@ -0,0 +1,327 @@
+package udfs
+
+import (
+	"sync"
+	"syscall"
+	"time"
+
+	. "asdf"
+)
+
+// disk-pressure eviction, least recently pulled first
+//
+// used percent of dir over high watermark, evict files @local until below low
+// the trashed @dir is purged first, NOT wait TrashGrace
+// the evicted is just removed @local(NOT tombstone), if other node of group has it
+// so the last copy is NOT evicted, see evictEntry
+// atime is of pull @this node, the follower's is the push/touch order mostly
+//
+// access time of pull is flushed to entry(extAtime), see access.go
+// bucket lru: idir(1) + atime(4) + bkdr(4) + digest ==> empty
+// atime is the last pull or push/touch, updated with the entry @dbPutTx/dbDelTx
+// pinned entry is NOT indexed, so NOT evicted
+//
+// just the local store(file of entry), the freed is known
+// NOT the volume store, its deleted is garbage until compacted, NOT freed now
+// NOT the s3 store, NOT @local
+
+const (
+	deftEvictInterval = 60 // second
+
+	sizeofLruKey = SizeofByte + 2*SizeofInt32 + DigestSize
+)
+
+var dbLruBucket = []byte("lru")
+
+type EvictConf struct {
+	High     int   `json:"high"`     // used percent to start eviction, 0 is disabled
+	Low      int   `json:"low"`      // used percent to stop eviction
+	Highs    []int `json:"highs"`    // high of Conf.Dirs, 0 is High
+	Lows     []int `json:"lows"`     // low of Conf.Dirs, 0 is Low
+	Interval int   `json:"interval"` // second, check dirs interval
+	Flush    int   `json:"flush"`    // second, old name of Conf.AccessFlush, used if it is 0
+}
+
+func (me *EvictConf) setDefault() {
+	if 0 == me.Interval {
+		me.Interval = deftEvictInterval
+	}
+}
+
+func (me *EvictConf) check(dirs int) int {
+	if (0 != len(me.Highs) && len(me.Highs) != dirs) || (0 != len(me.Lows) && len(me.Lows) != dirs) {
+		Log.Error("evict highs/lows count != dirs count:%d", dirs)
+
+		return StdErrError
+	}
+
+	for i := 0; i < dirs; i++ {
+		if high, low := me.watermark(i); 0 != high && (high > 100 || low < 0 || low >= high) {
+			Log.Error("evict dir:%d bad watermark high:%d low:%d", i, high, low)
+
+			return StdErrError
+		}
+	}
+
+	return 0
+}
+
+func (me *EvictConf) enabled() bool {
+	if 0 != me.High {
+		return true
+	}
+
+	for _, high := range me.Highs {
+		if 0 != high {
+			return true
+		}
+	}
+
+	return false
+}
+
+// watermark of Conf.Dirs[i], high is 0 if disabled
+func (me *EvictConf) watermark(i int) (int, int) {
+	high, low := me.High, me.Low
+
+	if i < len(me.Highs) && 0 != me.Highs[i] {
+		high = me.Highs[i]
+	}
+
+	if i < len(me.Lows) && 0 != me.Lows[i] {
+		low = me.Lows[i]
+	}
+
+	return high, low
+}
+
+func dbLruKey(entry *DbEntry) []byte {
+	key := make([]byte, sizeofLruKey)
+
+	atime := entry.atime
+	if entry.time > atime {
+		atime = entry.time
+	}
+
+	key[0] = entry.idir
+	Htonl(key[1:], uint32(atime))
+	Htonl(key[5:], uint32(entry.bkdr))
+	copy(key[9:], entry.digest[:])
+
+	return key
+}
+
+// index entries of bucket, as migration
+func dbMigrateLru(tx MetaTx, bucket []byte) error {
+	keys := [][]byte{}
+
+	err := tx.ForEach(bucket, nil, func(k, v []byte) error {
+		entry := &DbEntry{}
+		if nil == entry.FromBinary(v) && !entry.pinned {
+			keys = append(keys, dbLruKey(entry))
+		}
+
+		return nil
+	})
+	if nil != err {
+		return err
+	}
+
+	// NOT put @ForEach
+	for _, k := range keys {
+		if err = tx.Put(dbLruBucket, k, []byte{}); nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// used percent of dir
+func dirUsed(dir string) (int, uint64, error) {
+	st := syscall.Statfs_t{}
+	if err := syscall.Statfs(dir, &st); nil != err {
+		return 0, 0, err
+	}
+
+	total := uint64(st.Blocks) * uint64(st.Bsize)
+	if 0 == total {
+		return 0, 0, nil
+	}
+	used := total - uint64(st.Bavail)*uint64(st.Bsize)
+
+	return int(used * 100 / total), total, nil
+}
+
+// the evicting files @local, answered NOT exist to cmdExist
+// registered before checking the group, so 2 nodes NOT evict the last 2 copies
+type evictSet struct {
+	lock  sync.Mutex
+	files map[string]bool // bkdr(4) + digest
+}
+
+var evictings = &evictSet{
+	files: map[string]bool{},
+}
+
+func (me *evictSet) add(bkdr Bkdr, digest []byte) {
+	me.lock.Lock()
+	me.files[string(dbTombKey(bkdr, digest))] = true
+	me.lock.Unlock()
+}
+
+func (me *evictSet) del(bkdr Bkdr, digest []byte) {
+	me.lock.Lock()
+	delete(me.files, string(dbTombKey(bkdr, digest)))
+	me.lock.Unlock()
+}
+
+func (me *evictSet) has(bkdr Bkdr, digest []byte) bool {
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	return me.files[string(dbTombKey(bkdr, digest))]
+}
+
+// evict the file @local, if other node of group has it
+// NOT evict the last copy
+func evictEntry(entry *DbEntry) bool {
+	evictings.add(entry.bkdr, entry.digest[:])
+	defer evictings.del(entry.bkdr, entry.digest[:])
+
+	if !ep.existOthers(entry.bkdr, entry.digest[:], entry.ns) {
+		return false
+	}
+
+	blobStore.Delete(entry.File())
+
+	return nil == dbDel(entry.bkdr, entry.digest[:])
+}
+
+// evict the least recently pulled @dir, until need bytes freed
+// return count and bytes of evicted
+func evictDir(idir int, need uint64) (int, uint64) {
+	count, freed := 0, uint64(0)
+	begin := []byte{byte(idir)}
+
+	for freed < need {
+		entries := []*DbEntry{}
+		stales := [][]byte{}
+		from := begin
+
+		db.View(func(tx MetaTx) error {
+			return tx.ForEach(dbLruBucket, from, func(k, v []byte) error {
+				if string(k) == string(from) {
+					// done @last batch
+					return nil
+				} else if k[0] != byte(idir) || len(entries)+len(stales) >= dbScanBatch {
+					return ErrStop
+				}
+				begin = append([]byte{}, k...)
+
+				entry := &DbEntry{}
+				if len(k) != sizeofLruKey {
+					stales = append(stales, begin)
+				} else if v := tx.Get(dbBucket(Bkdr(Ntohl(k[5:]))), k[9:]); nil == v || nil != entry.FromBinary(v) {
+					// deleted
+					stales = append(stales, begin)
+				} else if entry.idir != k[0] || string(dbLruKey(entry)) != string(k) {
+					// moved to other dir, or accessed
+					stales = append(stales, begin)
+				} else {
+					entries = append(entries, entry)
+				}
+
+				return nil
+			})
+		})
+
+		if len(stales) > 0 {
+			db.Update(func(tx MetaTx) error {
+				for _, k := range stales {
+					tx.Delete(dbLruBucket, k)
+				}
+
+				return nil
+			})
+		}
+
+		if 0 == len(entries)+len(stales) {
+			// the end
+			break
+		}
+
+		for _, entry := range entries {
+			if entry.pinned || !evictEntry(entry) {
+				// the last copy, skip it
+				continue
+			}
+
+			count++
+			freed += entry.size
+
+			if freed >= need {
+				break
+			}
+		}
+	}
+
+	return count, freed
+}
+
+func evictCheck() {
+	for idir, dir := range dbConf.dirs() {
+		if !dbConf.active(idir) {
+			continue
+		}
+
+		high, low := conf.Evict.watermark(conf.findDir(dir))
+		if 0 == high {
+			continue
+		}
+
+		used, total, err := dirUsed(dir)
+		if nil != err {
+			Log.Error("evict statfs dir:%s error:%v", dir, err)
+
+			continue
+		} else if used < high {
+			continue
+		}
+
+		// the trashed first
+		need := uint64(used-low) * total / 100
+		purged, pfreed := dbTrashPurgeDir(idir, need)
+
+		count, freed := 0, uint64(0)
+		if pfreed < need {
+			count, freed = evictDir(idir, need-pfreed)
+		}
+
+		Log.Info("evict dir:%s used:%d%% high:%d%% low:%d%%, purged:%d bytes:%d, evicted:%d bytes:%d",
+			dir, used, high, low, purged, pfreed, count, freed)
+	}
+}
+
+// broker, background
+func evict() {
+	if _, ok := blobStore.(*LocalBlobStore); !ok {
+		if 0 != conf.Evict.High || 0 != len(conf.Evict.Highs) {
+			Log.Info("evict disabled, the blob store is NOT local files")
+		}
+
+		return
+	}
+
+	chCheck := time.Tick(time.Duration(conf.Evict.Interval) * time.Second)
+
+	for {
+		select {
+		case <-chCheck:
+			// the recent pulled is NOT evicted
+			accesses.flush()
+
+			evictCheck()
+		}
+	}
+}
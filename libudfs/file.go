@@ -2,13 +2,23 @@ package udfs
 
 import (
 	. "asdf"
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
+// tmp file: .digest.tmpXXXX
+const fileTmpSuffix = ".tmp"
+
 var dirLocks []*RwLock
 
 func newDirLocks() []*RwLock {
-	count := len(conf.Dirs)
+	// dirs of conf and the removed
+	count := len(dbConf.dirs())
 	locks := make([]*RwLock, count)
 
 	for i := 0; i < count; i++ {
@@ -27,6 +37,7 @@ func initFile(role Role) {
 type UdfsFile struct {
 	name FileName
 	idir int
+	loc  *VolumeLoc // of entry, maybe nil
 }
 
 func (me *UdfsFile) String() string {
@@ -39,6 +50,7 @@ func (me *UdfsFile) rhandle(handle func() error) error {
 	dirLocks[me.idir].RHandle(func() {
 		err = handle()
 	})
+	dirReport(me.idir, err)
 
 	return err
 }
@@ -49,6 +61,7 @@ func (me *UdfsFile) whandle(handle func() error) error {
 	dirLocks[me.idir].WHandle(func() {
 		err = handle()
 	})
+	dirReport(me.idir, err)
 
 	return err
 }
@@ -64,9 +77,14 @@ func (me *UdfsFile) Delete() error {
 	return err
 }
 
+// crash safe save
+// 1. write to tmp file @the same dir, fsync it
+// 2. rename tmp file to file, fsync the dir
+//
+// tmp file left by crash is cleaned by reconciliation
 func (me *UdfsFile) Save(buf []byte) error {
 	err := me.whandle(func() error {
-		return me.name.Save(buf)
+		return saveAtomic(me.String(), buf)
 	})
 	if nil != err {
 		Log.Error("save fils:%s error:%v", me.String(), err.Error())
@@ -75,6 +93,23 @@ func (me *UdfsFile) Save(buf []byte) error {
 	return err
 }
 
+func (me *UdfsFile) Load() ([]byte, error) {
+	var buf []byte
+
+	err := me.rhandle(func() error {
+		var err error
+
+		buf, err = ioutil.ReadFile(me.String())
+
+		return err
+	})
+	if nil != err {
+		Log.Error("load fils:%s error:%v", me.String(), err.Error())
+	}
+
+	return buf, err
+}
+
 func (me *UdfsFile) Touch(Time Time32) error {
 	err := me.whandle(func() error {
 		return me.name.Touch(Time)
@@ -97,3 +132,80 @@ func (me *UdfsFile) Exist() bool {
 
 	return exist
 }
+
+// file: dir/xxxx/xxxx/digest
+func parseFile(filename string) (Bkdr, []byte, bool) {
+	path, name := filepath.Split(filename)
+	path = filepath.Clean(path)
+	low := filepath.Base(path)
+	high := filepath.Base(filepath.Dir(path))
+
+	if 4 != len(high) || 4 != len(low) {
+		return 0, nil, false
+	}
+
+	bkdr, err := strconv.ParseUint(high+low, 16, 32)
+	if nil != err {
+		return 0, nil, false
+	}
+
+	digest, err := hex.DecodeString(name)
+	if nil != err || DigestSize != len(digest) {
+		return 0, nil, false
+	}
+
+	return Bkdr(bkdr), digest, true
+}
+
+func isTmpFile(filename string) bool {
+	base := filepath.Base(filename)
+
+	return strings.HasPrefix(base, ".") && strings.Contains(base, fileTmpSuffix)
+}
+
+func fsyncDir(dir string) error {
+	f, err := os.Open(dir)
+	if nil != err {
+		return err
+	}
+	defer f.Close()
+
+	return f.Sync()
+}
+
+func saveAtomic(filename string, buf []byte) error {
+	dir := filepath.Dir(filename)
+
+	if err := os.MkdirAll(dir, 0775); nil != err {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(filename)+fileTmpSuffix)
+	if nil != err {
+		return err
+	}
+
+	// remove tmp file if failed
+	ok := false
+	defer func() {
+		if !ok {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(buf); nil != err {
+		return err
+	} else if err = tmp.Chmod(0664); nil != err {
+		return err
+	} else if err = tmp.Sync(); nil != err {
+		return err
+	} else if err = tmp.Close(); nil != err {
+		return err
+	} else if err = os.Rename(tmp.Name(), filename); nil != err {
+		return err
+	}
+	ok = true
+
+	return fsyncDir(dir)
+}
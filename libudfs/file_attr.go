@@ -0,0 +1,222 @@
+package udfs
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	. "asdf"
+)
+
+const (
+	maxContentType = 255
+	maxMetaKey     = 255
+	maxMetaValue   = 4096
+	maxMetaCount   = 64
+)
+
+var ErrBadAttr = errors.New("bad file attr")
+
+// file attributes, set at push
+// saved @DbEntry, carried @ProtoTransfer
+type FileAttr struct {
+	ns    byte // namespace id
+	size  uint64
+	ctype string            // content type
+	meta  map[string]string // custom attrs, maybe nil
+
+	// expiry, 0 is not set, at most one is set
+	// re-push with one of them replaces both, without them keeps them
+	ttl    Time32 // second, from the last push/touch
+	expire Time32 // absolute unix time, NOT extended by touch
+
+	pinned bool // NOT gc, set by cmdPin
+
+	client uint32 // the publisher pushed it, 0 is unknow, for quota
+}
+
+func (me *FileAttr) String() string {
+	return fmt.Sprintf("ns:%d size:%d ctype:%s meta:%v ttl:%d expire:%d pinned:%v client:%d",
+		me.ns,
+		me.size,
+		me.ctype,
+		me.meta,
+		me.ttl,
+		me.expire,
+		me.pinned,
+		me.client)
+}
+
+func (me *FileAttr) check() error {
+	if len(me.ctype) > maxContentType {
+		return ErrBadAttr
+	} else if len(me.meta) > maxMetaCount {
+		return ErrBadAttr
+	}
+
+	// binary of meta is one ext, see metaToBinary
+	size := 0
+	for k, v := range me.meta {
+		if Empty == k || len(k) > maxMetaKey || len(v) > maxMetaValue {
+			return ErrBadAttr
+		}
+
+		size += 2*SizeofInt16 + len(k) + len(v)
+	}
+
+	if size > maxProtoExtValue {
+		return ErrBadAttr
+	}
+
+	if 0 != me.ttl && 0 != me.expire {
+		return ErrBadAttr
+	} else if me.ttl > conf.MaxTTL {
+		return ErrBadAttr
+	} else if now := NowTime32(); me.expire > now && me.expire-now > conf.MaxTTL {
+		// the past is ok, maybe replicated late, just gc it
+		return ErrBadAttr
+	}
+
+	return nil
+}
+
+// expire time of the file, touched/pushed at time
+func (me *FileAttr) expireAt(time Time32) Time32 {
+	if 0 != me.expire {
+		return me.expire
+	} else if 0 != me.ttl {
+		return time + me.ttl
+	}
+
+	return time + conf.live(me.ns)
+}
+
+// tlv of FileAttr, without ns and size
+func (me *FileAttr) exts() []ProtoExt {
+	exts := []ProtoExt{}
+
+	if Empty != me.ctype {
+		exts = append(exts, ProtoExt{
+			tag:   extContentType,
+			value: []byte(me.ctype),
+		})
+	}
+
+	if len(me.meta) > 0 {
+		exts = append(exts, ProtoExt{
+			tag:   extMeta,
+			value: metaToBinary(me.meta),
+		})
+	}
+
+	if 0 != me.ttl {
+		exts = append(exts, extUint32(extTTL, uint32(me.ttl)))
+	}
+
+	if 0 != me.expire {
+		exts = append(exts, extUint32(extExpire, uint32(me.expire)))
+	}
+
+	if me.pinned {
+		exts = append(exts, extByte(extPin, 1))
+	}
+
+	if 0 != me.client {
+		exts = append(exts, extUint32(extClient, me.client))
+	}
+
+	return exts
+}
+
+func (me *FileAttr) ext(tag byte, value []byte) error {
+	var err error
+
+	switch tag {
+	case extContentType:
+		me.ctype = string(value)
+	case extMeta:
+		me.meta, err = metaFromBinary(value)
+	case extPin:
+		me.pinned = len(value) > 0 && 0 != value[0]
+	case extTTL, extExpire, extClient:
+		if len(value) < SizeofInt32 {
+			return ErrTooShortBuffer
+		}
+
+		switch tag {
+		case extTTL:
+			me.ttl = Time32(Ntohl(value))
+		case extExpire:
+			me.expire = Time32(Ntohl(value))
+		default:
+			me.client = Ntohl(value)
+		}
+	}
+
+	return err
+}
+
+// meta binary: [klen(uint16) + key + vlen(uint16) + value]...
+// sorted by key
+func metaToBinary(meta map[string]string) []byte {
+	keys := make([]string, 0, len(meta))
+	size := 0
+
+	for k, v := range meta {
+		keys = append(keys, k)
+		size += 2*SizeofInt16 + len(k) + len(v)
+	}
+	sort.Strings(keys)
+
+	bin := make([]byte, size)
+	offset := 0
+
+	for _, k := range keys {
+		for _, s := range [2]string{k, meta[k]} {
+			Htons(bin[offset:], uint16(len(s)))
+			offset += SizeofInt16
+
+			copy(bin[offset:], s)
+			offset += len(s)
+		}
+	}
+
+	return bin
+}
+
+func metaFromBinary(bin []byte) (map[string]string, error) {
+	meta := map[string]string{}
+
+	get := func() (string, error) {
+		if len(bin) < SizeofInt16 {
+			return Empty, ErrTooShortBuffer
+		}
+
+		n := int(Ntohs(bin))
+		bin = bin[SizeofInt16:]
+		if len(bin) < n {
+			return Empty, ErrTooShortBuffer
+		}
+
+		s := string(bin[:n])
+		bin = bin[n:]
+
+		return s, nil
+	}
+
+	for len(bin) > 0 {
+		k, err := get()
+		if nil != err {
+			return nil, err
+		}
+
+		v, err := get()
+		if nil != err {
+			return nil, err
+		}
+
+		meta[k] = v
+	}
+
+	return meta, nil
+}
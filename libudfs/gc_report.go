@@ -0,0 +1,262 @@
+package udfs
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	. "asdf"
+)
+
+// gc dry-run, what gc would delete
+//
+// the expiring of [now, now + days), by expiry index(the current live)
+// or by scanning entries with a new live, to check a Live change before applying it
+// new live is of all entries without ttl/expire, the namespace's live is ignored
+// the totals, then the digests by pages(ordered by expire if the current live)
+//
+// Conf.GcDryRun: the broker NOT gc, just log the report hourly
+
+const (
+	gcReportDay     = 86400 // 3600*24
+	GcReportMaxDays = 3650  // 10 years
+)
+
+var ErrBadGcReport = errors.New("bad gc report days/live")
+
+type gcTotal struct {
+	count int
+	bytes uint64
+}
+
+func (me *gcTotal) add(entry *DbEntry) {
+	me.count++
+	me.bytes += entry.size
+}
+
+type gcReport struct {
+	now  Time32
+	days int
+
+	buckets map[uint16]*gcTotal
+	dirs    map[byte]*gcTotal
+	daily   []gcTotal // [0] is expired now
+	total   gcTotal
+}
+
+func newGcReport(now Time32, days int) *gcReport {
+	return &gcReport{
+		now:     now,
+		days:    days,
+		buckets: map[uint16]*gcTotal{},
+		dirs:    map[byte]*gcTotal{},
+		daily:   make([]gcTotal, days+1),
+	}
+}
+
+// expiring before it
+func (me *gcReport) until() Time32 {
+	return time32Add(me.now, uint64(me.days)*gcReportDay)
+}
+
+// NOT overflow
+func time32Add(time Time32, second uint64) Time32 {
+	if v := uint64(time) + second; v < math.MaxUint32 {
+		return Time32(v)
+	}
+
+	return math.MaxUint32
+}
+
+func (me *gcReport) add(entry *DbEntry, expire Time32) {
+	bucket := uint16(entry.bkdr)
+	if nil == me.buckets[bucket] {
+		me.buckets[bucket] = &gcTotal{}
+	}
+	me.buckets[bucket].add(entry)
+
+	if nil == me.dirs[entry.idir] {
+		me.dirs[entry.idir] = &gcTotal{}
+	}
+	me.dirs[entry.idir].add(entry)
+
+	day := 0
+	if expire >= me.now {
+		day = 1 + int(expire-me.now)/gcReportDay
+	}
+	me.daily[day].add(entry)
+
+	me.total.add(entry)
+}
+
+// a page(dbScanBatch) of the expiring entries of db from cursor
+// live is 0: by expiry index, ordered by expire
+// else: by scanning entries, NOT ordered
+func (me *gcReport) scanPage(tx MetaTx, live Time32, cursor []byte, handle func(entry *DbEntry, expire Time32)) ([]byte, error) {
+	until := me.until()
+
+	if 0 != live {
+		return dbScanTx(tx, dbBuckets(tx), cursor, func(_, k, v []byte) (bool, error) {
+			entry := &DbEntry{}
+			if nil != entry.FromBinary(v) || entry.pinned {
+				return false, nil
+			}
+
+			expire := time32Add(entry.time, uint64(live))
+			if 0 != entry.expire || 0 != entry.ttl {
+				expire = entry.expireAt(entry.time)
+			}
+
+			if expire >= until {
+				return false, nil
+			}
+			handle(entry, expire)
+
+			return true, nil
+		})
+	}
+
+	return dbScanTx(tx, [][]byte{dbExpireBucket}, cursor, func(_, k, v []byte) (bool, error) {
+		if len(k) != sizeofDbExpireKey {
+			return false, nil
+		}
+
+		expire := Time32(Ntohl(k))
+		if expire >= until {
+			return false, ErrStop
+		}
+
+		entry := &DbEntry{}
+		if v := tx.Get(dbBucket(Bkdr(Ntohl(k[4:]))), k[8:]); nil == v || nil != entry.FromBinary(v) {
+			// stale key
+			return false, nil
+		} else if entry.pinned {
+			return false, nil
+		}
+		handle(entry, expire)
+
+		return true, nil
+	})
+}
+
+// the totals of all the expiring
+func (me *gcReport) scan(tx MetaTx, live Time32) error {
+	var cursor []byte
+
+	for {
+		next, err := me.scanPage(tx, live, cursor, me.add)
+		if nil != err || nil == next {
+			return err
+		}
+
+		cursor = next
+	}
+}
+
+// the totals
+func (me *gcReport) print(w io.Writer) {
+	buckets := make([]int, 0, len(me.buckets))
+	for bucket := range me.buckets {
+		buckets = append(buckets, int(bucket))
+	}
+	sort.Ints(buckets)
+
+	for _, bucket := range buckets {
+		total := me.buckets[uint16(bucket)]
+
+		fmt.Fprintf(w, "bucket:%04x count:%d bytes:%d\n", bucket, total.count, total.bytes)
+	}
+
+	dirs := make([]int, 0, len(me.dirs))
+	for idir := range me.dirs {
+		dirs = append(dirs, int(idir))
+	}
+	sort.Ints(dirs)
+
+	for _, idir := range dirs {
+		total := me.dirs[byte(idir)]
+
+		fmt.Fprintf(w, "dir:%d count:%d bytes:%d\n", idir, total.count, total.bytes)
+	}
+
+	for day, total := range me.daily {
+		if 0 == day {
+			fmt.Fprintf(w, "expired count:%d bytes:%d\n", total.count, total.bytes)
+		} else {
+			fmt.Fprintf(w, "day:%d count:%d bytes:%d\n", day, total.count, total.bytes)
+		}
+	}
+
+	fmt.Fprintf(w, "total count:%d bytes:%d\n", me.total.count, me.total.bytes)
+}
+
+// days <= GcReportMaxDays, the daily totals @memory
+func gcReportGood(days, live int) bool {
+	return days >= 0 && days <= GcReportMaxDays && live >= 0 && uint64(live) <= math.MaxUint32
+}
+
+// gc dry-run of udfs.db
+// filename is Empty: by the local broker, see dbList
+// days: the report of next days, 0 is just the expired
+// live: 0 is the current, else the new live(second) to check
+func GcReport(w io.Writer, filename string, days, live int) error {
+	if !gcReportGood(days, live) {
+		return ErrBadGcReport
+	}
+
+	return dbList(w, filename, &ProtoList{
+		name: listGc,
+		days: uint32(days),
+		live: uint32(live),
+	})
+}
+
+// a page of the expiring digests from cursor, the totals @the first page
+func gcReportTx(w io.Writer, tx MetaTx, days, live int, cursor []byte) ([]byte, error) {
+	if !gcReportGood(days, live) {
+		return nil, ErrBadGcReport
+	}
+
+	report := newGcReport(NowTime32(), days)
+	if nil == cursor {
+		if err := report.scan(tx, Time32(live)); nil != err {
+			return nil, err
+		}
+
+		report.print(w)
+	}
+
+	return report.scanPage(tx, Time32(live), cursor, func(entry *DbEntry, expire Time32) {
+		fmt.Fprintf(w, "expire:%v bkdr:%x dir:%d ns:%d size:%d digest:%s\n",
+			expire.Unix(),
+			entry.bkdr,
+			entry.idir,
+			entry.ns,
+			entry.size,
+			hex.EncodeToString(entry.digest[:]))
+	})
+}
+
+// log what gc would delete, for Conf.GcDryRun
+// just totals, the digests maybe millions, see GcReport
+func gcDryRun() {
+	report := newGcReport(NowTime32(), 0)
+
+	err := db.View(func(tx MetaTx) error {
+		return report.scan(tx, 0)
+	})
+	if nil != err {
+		Log.Error("gc dry-run error:%v", err)
+
+		return
+	}
+
+	Log.Info("gc dry-run: would delete count:%d bytes:%d", report.total.count, report.total.bytes)
+
+	for idir, total := range report.dirs {
+		Log.Info("gc dry-run: would delete dir:%d count:%d bytes:%d", idir, total.count, total.bytes)
+	}
+}
@@ -1,13 +1,30 @@
 package udfs
 
-func init() {
-	initEnv()
-	initConf()
+import (
+	"sync"
+)
+
+var initBaseOnce sync.Once
+
+// env and conf(etcd), lazy
+// the tool with dbfile NOT need it
+func initBase() {
+	initBaseOnce.Do(func() {
+		initEnv()
+		initConf()
+	})
 }
 
 func initRole(role Role) {
+	initBase()
+	initAuth(role)
+
 	initDb(role)
+	initBlobStore(role)
 	initDbConf(role)
 	initFile(role)
+	initDirHealth(role)
 	initEndPoint(role)
+	initXrepl(role)
+	initReconcile(role)
 }
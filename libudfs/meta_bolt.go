@@ -0,0 +1,159 @@
+package udfs
+
+import (
+	. "asdf"
+
+	"github.com/boltdb/bolt"
+)
+
+func newBoltStore(filename string) (*BoltStore, error) {
+	bdb, err := bolt.Open(filename, 0755, nil)
+	if nil != err {
+		Log.Error("open db:%s error:%v", filename, err)
+
+		return nil, err
+	}
+
+	return &BoltStore{
+		db: bdb,
+	}, nil
+}
+
+// MetaStore of boltdb
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func (me *BoltStore) Get(bucket, key []byte) ([]byte, error) {
+	var value []byte
+
+	err := me.View(func(tx MetaTx) error {
+		v := tx.Get(bucket, key)
+		if nil == v {
+			return ErrNoExist
+		}
+
+		value = append([]byte{}, v...)
+
+		return nil
+	})
+
+	return value, err
+}
+
+func (me *BoltStore) Put(bucket, key, value []byte) error {
+	return me.Update(func(tx MetaTx) error {
+		return tx.Put(bucket, key, value)
+	})
+}
+
+func (me *BoltStore) Delete(bucket, key []byte) error {
+	return me.Update(func(tx MetaTx) error {
+		return tx.Delete(bucket, key)
+	})
+}
+
+func (me *BoltStore) ForEach(bucket, begin []byte, handle func(k, v []byte) error) error {
+	return me.View(func(tx MetaTx) error {
+		return tx.ForEach(bucket, begin, handle)
+	})
+}
+
+func (me *BoltStore) View(handle func(tx MetaTx) error) error {
+	return me.db.View(func(tx *bolt.Tx) error {
+		return handle(&boltTx{tx})
+	})
+}
+
+func (me *BoltStore) Update(handle func(tx MetaTx) error) error {
+	return me.db.Update(func(tx *bolt.Tx) error {
+		return handle(&boltTx{tx})
+	})
+}
+
+func (me *BoltStore) Batch(handle func(tx MetaTx) error) error {
+	return me.db.Batch(func(tx *bolt.Tx) error {
+		return handle(&boltTx{tx})
+	})
+}
+
+func (me *BoltStore) Close() error {
+	return me.db.Close()
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (me *boltTx) Get(bucket, key []byte) []byte {
+	b := me.tx.Bucket(bucket)
+	if nil == b {
+		return nil
+	}
+
+	return b.Get(key)
+}
+
+func (me *boltTx) Put(bucket, key, value []byte) error {
+	b, err := me.tx.CreateBucketIfNotExists(bucket)
+	if nil != err {
+		return err
+	}
+
+	return b.Put(key, value)
+}
+
+func (me *boltTx) Delete(bucket, key []byte) error {
+	b := me.tx.Bucket(bucket)
+	if nil == b {
+		return nil
+	}
+
+	return b.Delete(key)
+}
+
+func (me *boltTx) ForEach(bucket, begin []byte, handle func(k, v []byte) error) error {
+	b := me.tx.Bucket(bucket)
+	if nil == b {
+		return nil
+	}
+
+	var k, v []byte
+
+	c := b.Cursor()
+	if nil == begin {
+		k, v = c.First()
+	} else {
+		k, v = c.Seek(begin)
+	}
+
+	for ; nil != k; k, v = c.Next() {
+		if err := handle(k, v); ErrStop == err {
+			return nil
+		} else if nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (me *boltTx) Buckets(handle func(name []byte) error) error {
+	err := me.tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		return handle(name)
+	})
+	if ErrStop == err {
+		return nil
+	}
+
+	return err
+}
+
+func (me *boltTx) NextSequence(bucket []byte) (uint64, error) {
+	b, err := me.tx.CreateBucketIfNotExists(bucket)
+	if nil != err {
+		return 0, err
+	}
+
+	return b.NextSequence()
+}
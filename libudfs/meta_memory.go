@@ -0,0 +1,265 @@
+package udfs
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	. "asdf"
+)
+
+var ErrReadOnly = errors.New("read only tx")
+
+func newMemStore() *MemStore {
+	return &MemStore{
+		buckets: map[string]*memBucket{},
+	}
+}
+
+// MemStore of memory, for test and ephemeral cache node
+// all data lost after exit
+type MemStore struct {
+	lock    sync.RWMutex
+	buckets map[string]*memBucket
+}
+
+type memBucket struct {
+	kvs map[string][]byte
+	seq uint64
+}
+
+func (me *MemStore) Get(bucket, key []byte) ([]byte, error) {
+	var value []byte
+
+	err := me.View(func(tx MetaTx) error {
+		v := tx.Get(bucket, key)
+		if nil == v {
+			return ErrNoExist
+		}
+
+		value = append([]byte{}, v...)
+
+		return nil
+	})
+
+	return value, err
+}
+
+func (me *MemStore) Put(bucket, key, value []byte) error {
+	return me.Update(func(tx MetaTx) error {
+		return tx.Put(bucket, key, value)
+	})
+}
+
+func (me *MemStore) Delete(bucket, key []byte) error {
+	return me.Update(func(tx MetaTx) error {
+		return tx.Delete(bucket, key)
+	})
+}
+
+func (me *MemStore) ForEach(bucket, begin []byte, handle func(k, v []byte) error) error {
+	return me.View(func(tx MetaTx) error {
+		return tx.ForEach(bucket, begin, handle)
+	})
+}
+
+func (me *MemStore) View(handle func(tx MetaTx) error) error {
+	me.lock.RLock()
+	defer me.lock.RUnlock()
+
+	return handle(me.newTx(false))
+}
+
+func (me *MemStore) Update(handle func(tx MetaTx) error) error {
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	tx := me.newTx(true)
+	if err := handle(tx); nil != err {
+		// drop the writes
+		return err
+	}
+
+	tx.commit()
+
+	return nil
+}
+
+func (me *MemStore) Batch(handle func(tx MetaTx) error) error {
+	return me.Update(handle)
+}
+
+func (me *MemStore) Close() error {
+	return nil
+}
+
+func (me *MemStore) newTx(writable bool) *memTx {
+	return &memTx{
+		store:    me,
+		writable: writable,
+		writes:   map[string]map[string][]byte{},
+		seqs:     map[string]uint64{},
+	}
+}
+
+// writes are pending until commit
+type memTx struct {
+	store    *MemStore
+	writable bool
+	writes   map[string]map[string][]byte // nil value is deleted
+	seqs     map[string]uint64
+}
+
+func (me *memTx) Get(bucket, key []byte) []byte {
+	if w, ok := me.writes[string(bucket)]; ok {
+		if v, ok := w[string(key)]; ok {
+			return v
+		}
+	}
+
+	if b, ok := me.store.buckets[string(bucket)]; ok {
+		return b.kvs[string(key)]
+	}
+
+	return nil
+}
+
+func (me *memTx) write(bucket, key, value []byte) error {
+	if !me.writable {
+		return ErrReadOnly
+	}
+
+	w, ok := me.writes[string(bucket)]
+	if !ok {
+		w = map[string][]byte{}
+		me.writes[string(bucket)] = w
+	}
+	w[string(key)] = value
+
+	return nil
+}
+
+func (me *memTx) Put(bucket, key, value []byte) error {
+	if nil == value {
+		value = []byte{}
+	}
+
+	return me.write(bucket, key, append([]byte{}, value...))
+}
+
+func (me *memTx) Delete(bucket, key []byte) error {
+	return me.write(bucket, key, nil)
+}
+
+func (me *memTx) ForEach(bucket, begin []byte, handle func(k, v []byte) error) error {
+	keys := []string{}
+	seen := map[string]bool{}
+
+	add := func(k string) {
+		if !seen[k] && (nil == begin || k >= string(begin)) {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+
+	if b, ok := me.store.buckets[string(bucket)]; ok {
+		for k := range b.kvs {
+			add(k)
+		}
+	}
+
+	for k := range me.writes[string(bucket)] {
+		add(k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := me.Get(bucket, []byte(k))
+		if nil == v {
+			continue
+		}
+
+		if err := handle([]byte(k), v); ErrStop == err {
+			return nil
+		} else if nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (me *memTx) Buckets(handle func(name []byte) error) error {
+	names := []string{}
+
+	for name := range me.store.buckets {
+		names = append(names, name)
+	}
+
+	for name := range me.writes {
+		if _, ok := me.store.buckets[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := handle([]byte(name)); ErrStop == err {
+			return nil
+		} else if nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (me *memTx) NextSequence(bucket []byte) (uint64, error) {
+	if !me.writable {
+		return 0, ErrReadOnly
+	}
+
+	seq, ok := me.seqs[string(bucket)]
+	if !ok {
+		if b, ok := me.store.buckets[string(bucket)]; ok {
+			seq = b.seq
+		}
+	}
+
+	seq++
+	me.seqs[string(bucket)] = seq
+
+	return seq, nil
+}
+
+func (me *memTx) bucket(name string) *memBucket {
+	b, ok := me.store.buckets[name]
+	if !ok {
+		b = &memBucket{
+			kvs: map[string][]byte{},
+		}
+		me.store.buckets[name] = b
+	}
+
+	return b
+}
+
+func (me *memTx) commit() {
+	for name, w := range me.writes {
+		b := me.bucket(name)
+
+		for k, v := range w {
+			if nil == v {
+				delete(b.kvs, k)
+			} else {
+				b.kvs[k] = v
+			}
+		}
+	}
+
+	for name, seq := range me.seqs {
+		me.bucket(name).seq = seq
+	}
+}
@@ -0,0 +1,65 @@
+package udfs
+
+import (
+	"errors"
+)
+
+// metadata store
+//
+// key/value in named buckets, keys of bucket are ordered
+// put create the bucket if not exist
+// get/delete/iterate the missing bucket is ok(nil/nothing)
+
+const (
+	metaStoreBolt   = "bolt"
+	metaStoreMemory = "memory"
+)
+
+// iterate handle return it to stop, NOT an error
+var ErrStop = errors.New("stop")
+
+type MetaTx interface {
+	// value is valid only in tx, nil if not exist
+	Get(bucket, key []byte) []byte
+	Put(bucket, key, value []byte) error
+	Delete(bucket, key []byte) error
+
+	// iterate bucket from key begin(nil is first), by key order
+	// handle MUST NOT modify the bucket
+	ForEach(bucket, begin []byte, handle func(k, v []byte) error) error
+
+	// iterate bucket names
+	Buckets(handle func(name []byte) error) error
+
+	// auto increment of bucket
+	NextSequence(bucket []byte) (uint64, error)
+}
+
+type MetaStore interface {
+	// value is copied, ErrNoExist if not exist
+	Get(bucket, key []byte) ([]byte, error)
+	Put(bucket, key, value []byte) error
+	Delete(bucket, key []byte) error
+
+	// read only tx
+	ForEach(bucket, begin []byte, handle func(k, v []byte) error) error
+
+	// read only tx
+	View(handle func(tx MetaTx) error) error
+	// read-write tx, rollback if handle return error
+	Update(handle func(tx MetaTx) error) error
+	// like Update, but maybe merged with other batch
+	// handle maybe called more times, MUST be idempotent
+	Batch(handle func(tx MetaTx) error) error
+
+	Close() error
+}
+
+func newMetaStore(name string) (MetaStore, error) {
+	switch name {
+	case metaStoreMemory:
+		return newMemStore(), nil
+	default:
+		return newBoltStore(conf.DbFileName.Abs().String())
+	}
+}
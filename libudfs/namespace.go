@@ -0,0 +1,137 @@
+package udfs
+
+import (
+	. "asdf"
+)
+
+const nsDefault byte = 0 // file without namespace
+
+// namespace config
+// override Conf.Live/Replication/Dirs/TouchOnPull for files pushed with it
+type NamespaceConf struct {
+	ID          byte      `json:"id"` // saved in DbEntry, NOT change it
+	Name        string    `json:"name"`
+	Live        Time32    `json:"live"`
+	Replication int       `json:"replication"`
+	Dirs        []string  `json:"dirs"` // subset of Conf.Dirs, empty is all
+	Quota       QuotaConf `json:"quota"`
+	TouchOnPull *bool     `json:"touchonpull"` // touch the pulled, nil is Conf.TouchOnPull
+}
+
+func (me *NamespaceConf) check() int {
+	if nsDefault == me.ID {
+		Log.Error("namespace:%s id:%d is reserved", me.Name, me.ID)
+
+		return StdErrError
+	} else if Empty == me.Name {
+		Log.Error("namespace id:%d empty name", me.ID)
+
+		return StdErrError
+	} else if 0 != me.Replication && (me.Replication < minReplication || me.Replication > maxReplication) {
+		Log.Error("namespace:%s bad replication:%d", me.Name, me.Replication)
+
+		return StdErrError
+	}
+
+	for _, dir := range me.Dirs {
+		if InvalidID == conf.findDir(dir) {
+			Log.Error("namespace:%s dir:%s not in dirs", me.Name, dir)
+
+			return StdErrNoDir
+		}
+	}
+
+	return 0
+}
+
+func (me *Conf) checkNamespaces() int {
+	for i, ns := range me.Namespaces {
+		if errno := ns.check(); 0 != errno {
+			return errno
+		}
+
+		for _, other := range me.Namespaces[:i] {
+			if other.ID == ns.ID || other.Name == ns.Name {
+				Log.Error("namespace:%s dup with namespace:%s", ns.Name, other.Name)
+
+				return StdErrError
+			}
+		}
+	}
+
+	return 0
+}
+
+// nil if ns is nsDefault or not in config
+func (me *Conf) namespace(ns byte) *NamespaceConf {
+	if nsDefault != ns {
+		for _, v := range me.Namespaces {
+			if ns == v.ID {
+				return v
+			}
+		}
+	}
+
+	return nil
+}
+
+func (me *Conf) findNamespace(name string) (byte, error) {
+	if Empty == name {
+		return nsDefault, nil
+	}
+
+	for _, v := range me.Namespaces {
+		if name == v.Name {
+			return v.ID, nil
+		}
+	}
+
+	Log.Error("namespace:%s not in config", name)
+
+	return nsDefault, ErrNoExist
+}
+
+func (me *Conf) live(ns byte) Time32 {
+	if v := me.namespace(ns); nil != v && 0 != v.Live {
+		return v.Live
+	}
+
+	return me.Live
+}
+
+func (me *Conf) replication(ns byte) int {
+	replication := me.Replication
+	if v := me.namespace(ns); nil != v && 0 != v.Replication {
+		replication = v.Replication
+	}
+
+	// NOT more than nodes
+	if replication > len(me.Nodes) {
+		replication = len(me.Nodes)
+	}
+
+	return replication
+}
+
+// max replication of all namespaces
+func (me *Conf) replicationMax() int {
+	replication := me.replication(nsDefault)
+
+	for _, v := range me.Namespaces {
+		if n := me.replication(v.ID); n > replication {
+			replication = n
+		}
+	}
+
+	return replication
+}
+
+// dirs the ns can use
+// nil is all
+func (me *Conf) nsDirs(ns byte) []string {
+	if v := me.namespace(ns); nil != v && len(v.Dirs) > 0 {
+		return v.Dirs
+	}
+
+	return nil
+}
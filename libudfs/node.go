@@ -1,67 +1,215 @@
 package udfs
 
 import (
+	"encoding/json"
+	"net"
+	"strconv"
+
 	. "asdf"
 )
 
-func tcpAddr(ip string) *TcpAddr {
-	return NewTcpAddr(conf.Port, ip)
+const deftBind = "0.0.0.0"
+
+// node config
+//
+// json is "host", "host:port" or object
+type NodeConf struct {
+	Name string `json:"name"` // default is host, or host:port if port is set
+	Host string `json:"host"`
+	Port int    `json:"port"` // default is Conf.Port
+	Bind string `json:"bind"` // listen address, default is 0.0.0.0
+}
+
+func (me *NodeConf) UnmarshalJSON(data []byte) error {
+	var host string
+
+	if err := json.Unmarshal(data, &host); nil != err {
+		// NOT string, object
+		type nodeConf NodeConf
+
+		return json.Unmarshal(data, (*nodeConf)(me))
+	}
+
+	if h, p, err := net.SplitHostPort(host); nil == err {
+		port, err := strconv.Atoi(p)
+		if nil != err {
+			return err
+		}
+
+		me.Name = host
+		me.Host = h
+		me.Port = port
+	} else {
+		me.Host = host
+	}
+
+	return nil
+}
+
+func (me *NodeConf) setDefault(port int) {
+	if 0 == me.Port {
+		me.Port = port
+	}
+
+	if Empty == me.Bind {
+		me.Bind = deftBind
+	}
+
+	if Empty == me.Name {
+		me.Name = me.Host
+	}
+}
+
+func (me *NodeConf) check() int {
+	if Empty == me.Host {
+		Log.Error("node:%s empty host", me.Name)
+
+		return StdErrError
+	}
+
+	// "host" node, check dup by name before setDefault
+	if Empty == me.Name {
+		me.Name = me.Host
+	}
+
+	return 0
 }
 
-func newNode(ip string) *Node {
+// addr to dial self @local
+func (me *NodeConf) local() *TcpAddr {
+	if deftBind == me.Bind {
+		return NewTcpAddr(me.Port, "127.0.0.1")
+	} else {
+		return NewTcpAddr(me.Port, me.Bind)
+	}
+}
+
+func newNode(node *NodeConf) *Node {
 	return &Node{
-		alive: true,
-		addr:  tcpAddr(ip),
+		alive:  true,
+		addr:   NewTcpAddr(node.Port, node.Host),
+		client: thisClient,
 	}
 }
 
 type Node struct {
-	alive bool
-	addr  *TcpAddr
+	alive  bool
+	addr   *TcpAddr
+	client *ClientConf // credential to call node, nil if auth disabled
+	flag   ProtoFlag   // request flag
 }
 
-var loopback = tcpAddr("127.0.0.1")
-
 func (me *Node) dial() (*TcpStream, error) {
-	if roleConsumer == ep.role {
-		return TcpStreamDial(loopback)
+	if nil != ep && roleConsumer == ep.role {
+		// consumer always dial local broker
+		return TcpStreamDial(conf.Nodes[thisNodeID].local())
 	} else {
 		return TcpStreamDial(me.addr)
 	}
 }
 
-func (me *Node) call(msg IBinary) error {
+// return reply data of ok response
+func (me *Node) request(msg IProto) ([]byte, error) {
 	stream, err := me.dial()
 	if nil != err {
 		Log.Info("dial error:%v", err)
 
-		return err
+		return nil, err
 	}
 	defer stream.Close()
 
-	err = protoWrite(stream, msg)
+	err = protoRequest(stream, msg, me.client)
 	if nil != err {
-		return err
+		return nil, err
 	}
 
 	return recvResponse(stream)
 }
 
-func (me *Node) push(bkdr Bkdr, time Time32, digest, content []byte) error {
+func (me *Node) call(msg IProto) error {
+	_, err := me.request(msg)
+
+	return err
+}
+
+func (me *Node) push(bkdr Bkdr, time Time32, digest, content []byte, attr *FileAttr) error {
 	msg := &ProtoTransfer{
-		ProtoHeader: NewProtoHeader(cmdPush, 0),
+		ProtoHeader: NewProtoHeader(cmdPush, me.flag),
 		bkdr:        newbkdr(bkdr, digest),
 		time:        newtime32(time),
 		digest:      newdigest(digest, content),
 		content:     content,
+		FileAttr:    *attr,
+	}
+
+	return me.call(msg)
+}
+
+// time is 0: del anyway
+// else: NOT del if the file is newer than time
+// force: NOT trash
+// return count of removed copies @node(and its followers if leader)
+func (me *Node) del(bkdr Bkdr, digest []byte, time Time32, force bool) (int, error) {
+	var flag ProtoFlag
+
+	if force {
+		flag = flagForce
+	}
+
+	msg := &ProtoIdentify{
+		ProtoHeader: NewProtoHeader(cmdDel, me.flag|flag),
+		bkdr:        newbkdr(bkdr, digest),
+		digest:      digest,
+		time:        time,
+	}
+
+	data, err := me.request(msg)
+	if nil != err {
+		return 0, err
+	} else if len(data) < SizeofInt32 {
+		// old peer, NOT reply the count
+		return 0, nil
+	}
+
+	return int(Ntohl(data)), nil
+}
+
+// consumer: return local filename
+// broker: save content @recv, return Empty
+func (me *Node) pull(bkdr Bkdr, digest []byte) (FileName, error) {
+	var flag ProtoFlag
+
+	if roleConsumer == ep.role {
+		flag = flagLocal
+	}
+
+	msg := &ProtoIdentify{
+		ProtoHeader: NewProtoHeader(cmdPull, me.flag|flag),
+		bkdr:        newbkdr(bkdr, digest),
+		digest:      digest,
+	}
+
+	data, err := me.request(msg)
+
+	return FileName(data), err
+}
+
+// time is 0: now
+func (me *Node) touch(bkdr Bkdr, digest []byte, time Time32) error {
+	msg := &ProtoIdentify{
+		ProtoHeader: NewProtoHeader(cmdTouch, me.flag),
+		bkdr:        newbkdr(bkdr, digest),
+		digest:      digest,
+		time:        time,
 	}
 
 	return me.call(msg)
 }
 
-func (me *Node) del(bkdr Bkdr, digest []byte) error {
+// ok if the file exist @node, NOT evicting
+func (me *Node) exist(bkdr Bkdr, digest []byte) error {
 	msg := &ProtoIdentify{
-		ProtoHeader: NewProtoHeader(cmdDel, 0),
+		ProtoHeader: NewProtoHeader(cmdExist, me.flag),
 		bkdr:        newbkdr(bkdr, digest),
 		digest:      digest,
 	}
@@ -69,22 +217,24 @@ func (me *Node) del(bkdr Bkdr, digest []byte) error {
 	return me.call(msg)
 }
 
-func (me *Node) pull(bkdr Bkdr, digest []byte) error {
+// restore from trash
+func (me *Node) undel(bkdr Bkdr, digest []byte) error {
 	msg := &ProtoIdentify{
-		ProtoHeader: NewProtoHeader(cmdPull, 0),
+		ProtoHeader: NewProtoHeader(cmdUndel, me.flag),
 		bkdr:        newbkdr(bkdr, digest),
 		digest:      digest,
 	}
 
-	// save content @recv
 	return me.call(msg)
 }
 
-func (me *Node) touch(bkdr Bkdr, digest []byte) error {
+// pin or unpin
+func (me *Node) pin(bkdr Bkdr, digest []byte, pin bool) error {
 	msg := &ProtoIdentify{
-		ProtoHeader: NewProtoHeader(cmdTouch, 0),
+		ProtoHeader: NewProtoHeader(cmdPin, me.flag),
 		bkdr:        newbkdr(bkdr, digest),
 		digest:      digest,
+		pin:         pin,
 	}
 
 	return me.call(msg)
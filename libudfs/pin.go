@@ -0,0 +1,86 @@
+package udfs
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	. "asdf"
+)
+
+// pinning(legal hold)
+//
+// pinned entry is NOT gc, whatever live/ttl/expire, until unpinned
+// the flag is @entry(extPin), pinned by cmdPin, leader re-do it to followers
+//
+// bucket pin: bkdr(4) + digest ==> empty
+// updated with the entry @the same tx(dbPutTx/dbDelTx), for listing
+
+const sizeofPinKey = SizeofInt32 + DigestSize
+
+var dbPinBucket = []byte("pin")
+
+func dbPinKey(bkdr Bkdr, digest []byte) []byte {
+	key := make([]byte, sizeofPinKey)
+
+	Htonl(key, uint32(bkdr))
+	copy(key[SizeofInt32:], digest)
+
+	return key
+}
+
+// index the pinned entry, or remove it
+func dbPinIndexTx(tx MetaTx, entry *DbEntry) error {
+	key := dbPinKey(entry.bkdr, entry.digest[:])
+
+	if entry.pinned {
+		return tx.Put(dbPinBucket, key, []byte{})
+	} else {
+		return tx.Delete(dbPinBucket, key)
+	}
+}
+
+// list pinned digests
+// filename is Empty: by the local broker, see dbList
+func PinList(w io.Writer, filename string) error {
+	return dbList(w, filename, &ProtoList{
+		name: listPin,
+	})
+}
+
+// a page of pinned from cursor, the count @the last page
+func pinListTx(w io.Writer, tx MetaTx, cursor []byte) ([]byte, error) {
+	next, err := dbScanTx(tx, [][]byte{dbPinBucket}, cursor, func(_, k, v []byte) (bool, error) {
+		if len(k) != sizeofPinKey {
+			return false, nil
+		}
+
+		bkdr := Bkdr(Ntohl(k))
+		digest := k[SizeofInt32:]
+
+		entry := &DbEntry{}
+		if v := tx.Get(dbBucket(bkdr), digest); nil == v || nil != entry.FromBinary(v) {
+			// stale, NOT exist
+			fmt.Fprintf(w, "bkdr:%x digest:%s missing\n", bkdr, hex.EncodeToString(digest))
+		} else {
+			fmt.Fprintf(w, "%s\n", entry.String())
+		}
+
+		return true, nil
+	})
+	if nil != err || nil != next {
+		return next, err
+	}
+
+	count := 0
+	tx.ForEach(dbPinBucket, nil, func(k, v []byte) error {
+		if len(k) == sizeofPinKey {
+			count++
+		}
+
+		return nil
+	})
+	fmt.Fprintf(w, "pinned:%d\n", count)
+
+	return nil, nil
+}
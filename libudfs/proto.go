@@ -10,8 +10,12 @@ func protoRead(stream *TcpStream, request bool) (*ProtoHeader, IBinary, error) {
 		return nil, nil, err
 	}
 
+	return protoParse(bin, request)
+}
+
+func protoParse(bin []byte, request bool) (*ProtoHeader, IBinary, error) {
 	hdr := &ProtoHeader{}
-	err = hdr.FromBinary(bin)
+	err := hdr.FromBinary(bin)
 	if nil != err {
 		Log.Info("read proto header error:%v", err)
 
@@ -32,21 +36,32 @@ func protoRead(stream *TcpStream, request bool) (*ProtoHeader, IBinary, error) {
 		return nil, nil, ErrBadProto
 	}
 
+	if hdr.flag.Has(flagAuth) {
+		// cut auth trailer, check it @authCheck
+		if len(bin) < hdr.Size()+sizeofProtoAuth {
+			return nil, nil, ErrTooShortBuffer
+		}
+
+		bin = bin[:len(bin)-sizeofProtoAuth]
+	}
+
 	var msg IBinary
 
 	if request {
 		switch cmd {
 		case cmdPush:
 			msg = &ProtoTransfer{}
-		case cmdDel, cmdTouch, cmdPull:
+		case cmdDel, cmdTouch, cmdPull, cmdPin, cmdUndel, cmdExist:
 			msg = &ProtoIdentify{}
+		case cmdList:
+			msg = &ProtoList{}
 		}
 	} else {
 		switch cmd {
-		case cmdPush, cmdDel, cmdTouch:
+		case cmdPush, cmdDel, cmdTouch, cmdPin, cmdUndel, cmdExist, cmdList:
 			msg = &ProtoError{}
 		case cmdPull:
-			if hdr.flag.Has(flagError) {
+			if hdr.flag.Has(flagError) || hdr.flag.Has(flagLocal) {
 				msg = &ProtoError{}
 			} else {
 				msg = &ProtoTransfer{}
@@ -75,10 +90,41 @@ func protoWrite(stream *TcpStream, msg IBinary) error {
 	return stream.Write(bin)
 }
 
+// write request, with credential of client
+// if client is nil, no credential
+func protoRequest(stream *TcpStream, msg IProto, client *ClientConf) error {
+	if nil == client {
+		return protoWrite(stream, msg)
+	}
+
+	hdr := msg.Header()
+	hdr.flag |= flagAuth
+	hdr.client = client.ID
+
+	bin := make([]byte, msg.Size())
+	err := msg.ToBinary(bin)
+	if nil != err {
+		Log.Info("write proto error:%v", err)
+
+		return err
+	}
+
+	return stream.Write(authSign(bin, client))
+}
+
 func replyOk(stream *TcpStream, cmd ProtoCmd) error {
 	return replyError(stream, cmd, 0, Empty)
 }
 
+func replyData(stream *TcpStream, cmd ProtoCmd, data []byte) error {
+	msg := &ProtoError{
+		ProtoHeader: NewProtoHeader(cmd, flagResponse),
+		errs:        data,
+	}
+
+	return protoWrite(stream, msg)
+}
+
 func replyError(stream *TcpStream, cmd ProtoCmd, Err int, Errs string) error {
 	flag := flagResponse
 	if Err != 0 {
@@ -94,41 +140,66 @@ func replyError(stream *TcpStream, cmd ProtoCmd, Err int, Errs string) error {
 	return protoWrite(stream, msg)
 }
 
-func replyFile(stream *TcpStream, cmd ProtoCmd, Time Time32, bkdr Bkdr, digest, content []byte) error {
+// reply local filename of pull
+func replyLocal(stream *TcpStream, cmd ProtoCmd, filename FileName) error {
+	msg := &ProtoError{
+		ProtoHeader: NewProtoHeader(cmd, flagResponse|flagLocal),
+		errs:        []byte(filename),
+	}
+
+	return protoWrite(stream, msg)
+}
+
+func replyFile(stream *TcpStream, cmd ProtoCmd, Time Time32, bkdr Bkdr, digest, content []byte, attr *FileAttr) error {
 	msg := &ProtoTransfer{
 		ProtoHeader: NewProtoHeader(cmd, flagResponse),
 		bkdr:        newbkdr(bkdr, digest),
 		time:        Time,
 		digest:      newdigest(digest, content),
 		content:     content,
+		FileAttr:    *attr,
 	}
 
 	return protoWrite(stream, msg)
 }
 
-func recvResponse(stream *TcpStream) error {
+// return reply data of ok response
+func recvResponse(stream *TcpStream) ([]byte, error) {
 	_, msg, err := protoRead(stream, false)
 	if nil != err {
-		return err
+		return nil, err
 	}
 
 	switch obj := msg.(type) {
 	case *ProtoError:
-		return obj.Error()
+		return obj.Data(), obj.Error()
 	case *ProtoTransfer:
-		file := dbConf.File(obj.bkdr, obj.digest)
-		if err := file.Save(obj.content); nil != err {
-			return err
+		if dbTombed(obj.bkdr, obj.digest, obj.time) {
+			// deleted @local after it, NOT repair it
+			return nil, ErrNoExist
 		}
 
-		if err := file.Touch(obj.time); nil != err {
-			return err
+		entry, exist := dbEntry(obj.bkdr, obj.digest, obj.ns)
+		entry.time = obj.time
+		if !exist {
+			if _, err := dbConf.idir(obj.bkdr, obj.ns); nil != err {
+				return nil, err
+			}
+
+			entry.FileAttr = obj.FileAttr
 		}
 
-		_, err := dbAdd(obj.bkdr, obj.digest, obj.time)
+		file := entry.File()
+		if err := blobStore.Save(file, obj.content); nil != err {
+			return nil, err
+		}
 
-		return err
+		if err := blobStore.Touch(file, obj.time); nil != err {
+			return nil, err
+		}
+
+		return nil, dbPut(entry)
 	default:
-		return ErrBadIntf
+		return nil, ErrBadIntf
 	}
 }
@@ -6,8 +6,12 @@ const (
 	cmdPush  ProtoCmd = 0 // publisher ==> [leader] ==> follower
 	cmdTouch ProtoCmd = 1 // publisher ==> [leader] ==> follower
 	cmdPull  ProtoCmd = 2 // consumer  ==> leader ==> follower
-	cmdDel   ProtoCmd = 3 // gc
-	cmdEnd   ProtoCmd = 4
+	cmdDel   ProtoCmd = 3 // gc/publisher ==> [leader] ==> follower
+	cmdPin   ProtoCmd = 4 // publisher ==> [leader] ==> follower, pin/unpin
+	cmdUndel ProtoCmd = 5 // publisher ==> [leader] ==> follower, restore from trash
+	cmdExist ProtoCmd = 6 // broker ==> broker, check file before evict
+	cmdList  ProtoCmd = 7 // tool ==> local broker, live listing of udfs.db
+	cmdEnd   ProtoCmd = 8
 )
 
 var cmdStrings = [cmdEnd]string{
@@ -15,6 +19,10 @@ var cmdStrings = [cmdEnd]string{
 	cmdTouch: "touch",
 	cmdPull:  "pull",
 	cmdDel:   "del",
+	cmdPin:   "pin",
+	cmdUndel: "undel",
+	cmdExist: "exist",
+	cmdList:  "list",
 }
 
 func (me ProtoCmd) IsGood() bool {
@@ -7,6 +7,30 @@ import (
 	. "asdf"
 )
 
+// ProtoError.err
+const (
+	errnoOk     = 0
+	errnoError  = 1 // generic error
+	errnoAuth   = 2 // bad/no credential
+	errnoDenied = 3 // role has no permission
+	errnoQuota  = 4 // over quota of namespace/client
+)
+
+func errnoOf(err error) int {
+	switch err {
+	case nil:
+		return errnoOk
+	case ErrAuth:
+		return errnoAuth
+	case ErrDenied:
+		return errnoDenied
+	case ErrQuota:
+		return errnoQuota
+	default:
+		return errnoError
+	}
+}
+
 // create/delete/find response
 type ProtoError struct {
 	ProtoHeader
@@ -14,12 +38,15 @@ type ProtoError struct {
 	err int32
 	// nerrs uint32 // errs length, just protocol, not delete this line
 
-	errs []byte // maybe nil/empty
+	errs []byte // maybe nil/empty, if err is 0, it is reply data
 }
 
 func (me *ProtoError) Error() error {
 	if 0 == me.err {
 		return nil
+	} else if errnoQuota == me.err {
+		// caller maybe check it
+		return ErrQuota
 	} else if len(me.errs) > 0 {
 		return errors.New(string(me.errs))
 	} else {
@@ -27,6 +54,15 @@ func (me *ProtoError) Error() error {
 	}
 }
 
+// reply data of ok response
+func (me *ProtoError) Data() []byte {
+	if 0 == me.err {
+		return me.errs
+	} else {
+		return nil
+	}
+}
+
 func (me *ProtoError) String() string {
 	errs := Empty
 	if len(me.errs) > 0 {
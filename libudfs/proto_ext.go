@@ -0,0 +1,109 @@
+package udfs
+
+import (
+	"errors"
+
+	. "asdf"
+)
+
+// optional field
+// appended after the dynamic part of message, old peer ignore it
+//
+// binary: tag(uint8) + length(uint16) + value
+type ProtoExt struct {
+	tag   byte
+	value []byte
+}
+
+const (
+	extNamespace   byte = 1  // uint8, namespace id
+	extTime        byte = 2  // uint32, touch/del time
+	extContentType byte = 3  // string
+	extMeta        byte = 4  // custom attrs, see metaToBinary
+	extVolume      byte = 5  // VolumeLoc, just @DbEntry
+	extTTL         byte = 6  // uint32, second
+	extExpire      byte = 7  // uint32, absolute unix time
+	extPin         byte = 8  // uint8, 1 is pinned
+	extClient      byte = 9  // uint32, client id of the publisher, for quota
+	extAtime       byte = 10 // uint32, last pull, just @DbEntry
+)
+
+const (
+	sizeofProtoExtFixed = SizeofByte + SizeofInt16
+	maxProtoExtValue    = 0xffff // length is uint16
+)
+
+var ErrExtTooLong = errors.New("proto ext too long")
+
+func extByte(tag byte, v byte) ProtoExt {
+	return ProtoExt{
+		tag:   tag,
+		value: []byte{v},
+	}
+}
+
+func extUint32(tag byte, v uint32) ProtoExt {
+	value := make([]byte, SizeofInt32)
+	Htonl(value, v)
+
+	return ProtoExt{
+		tag:   tag,
+		value: value,
+	}
+}
+
+func extSize(exts []ProtoExt) int {
+	size := 0
+
+	for _, ext := range exts {
+		size += sizeofProtoExtFixed + len(ext.value)
+	}
+
+	return size
+}
+
+func extToBinary(bin []byte, exts []ProtoExt) error {
+	if len(bin) < extSize(exts) {
+		return ErrTooShortBuffer
+	}
+
+	offset := 0
+	for _, ext := range exts {
+		if len(ext.value) > maxProtoExtValue {
+			// NOT truncate it
+			return ErrExtTooLong
+		}
+
+		bin[offset] = ext.tag
+		Htons(bin[offset+SizeofByte:], uint16(len(ext.value)))
+		offset += sizeofProtoExtFixed
+
+		copy(bin[offset:], ext.value)
+		offset += len(ext.value)
+	}
+
+	return nil
+}
+
+// unknow tag is skipped
+func extFromBinary(bin []byte, handle func(tag byte, value []byte) error) error {
+	for len(bin) > 0 {
+		if len(bin) < sizeofProtoExtFixed {
+			return ErrTooShortBuffer
+		}
+
+		tag := bin[0]
+		n := int(Ntohs(bin[SizeofByte:]))
+		bin = bin[sizeofProtoExtFixed:]
+		if len(bin) < n {
+			return ErrTooShortBuffer
+		}
+
+		if err := handle(tag, bin[:n]); nil != err {
+			return err
+		}
+		bin = bin[n:]
+	}
+
+	return nil
+}
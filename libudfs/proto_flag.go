@@ -5,6 +5,10 @@ type ProtoFlag uint16
 const (
 	flagResponse ProtoFlag = 0x01 // only for response
 	flagError    ProtoFlag = 0x02 // only for response
+	flagAuth     ProtoFlag = 0x04 // only for request, with auth trailer
+	flagLocal    ProtoFlag = 0x08 // only for pull, reply local filename, not content
+	flagRemote   ProtoFlag = 0x10 // only for request, from remote cluster, NOT replicate back
+	flagForce    ProtoFlag = 0x20 // only for del, NOT trash, delete the file now
 )
 
 func (me ProtoFlag) Has(flag ProtoFlag) bool {
@@ -36,5 +40,21 @@ func (me ProtoFlag) String() string {
 		Append("error")
 	}
 
+	if me.Has(flagAuth) {
+		Append("auth")
+	}
+
+	if me.Has(flagLocal) {
+		Append("local")
+	}
+
+	if me.Has(flagRemote) {
+		Append("remote")
+	}
+
+	if me.Has(flagForce) {
+		Append("force")
+	}
+
 	return string(buf)
 }
@@ -12,6 +12,7 @@ type ProtoHeader struct {
 	version byte
 	cmd     ProtoCmd
 	flag    ProtoFlag
+	client  uint32 // client id, only for auth request
 }
 
 func NewProtoHeader(cmd ProtoCmd, flag ProtoFlag) ProtoHeader {
@@ -22,11 +23,22 @@ func NewProtoHeader(cmd ProtoCmd, flag ProtoFlag) ProtoHeader {
 	}
 }
 
+// all messages embed ProtoHeader
+type IProto interface {
+	IBinary
+	Header() *ProtoHeader
+}
+
+func (me *ProtoHeader) Header() *ProtoHeader {
+	return me
+}
+
 func (me *ProtoHeader) String() string {
-	return fmt.Sprintf("version:%d cmd:%s flag:%s",
+	return fmt.Sprintf("version:%d cmd:%s flag:%s client:%d",
 		me.version,
 		me.cmd.String(),
-		me.flag.String())
+		me.flag.String(),
+		me.client)
 }
 
 const sizeofProtoHeader = 2*SizeofByte + SizeofInt16 + SizeofInt32
@@ -44,6 +56,7 @@ func (me *ProtoHeader) ToBinary(bin []byte) error {
 	bin[1] = byte(me.cmd)
 
 	Htons(bin[2:], uint16(me.flag))
+	Htonl(bin[4:], me.client)
 
 	return nil
 }
@@ -57,6 +70,7 @@ func (me *ProtoHeader) FromBinary(bin []byte) error {
 	me.cmd = ProtoCmd(bin[1])
 
 	me.flag = ProtoFlag(Ntohs(bin[2:]))
+	me.client = Ntohl(bin[4:])
 
 	return nil
 }
@@ -15,12 +15,47 @@ type ProtoIdentify struct {
 	// ndigest uint32 // just protocol, not delete this line
 
 	digest []byte
+
+	// ext
+	time Time32 // touch/del time, 0 is now
+	pin  bool   // pin or unpin, just cmdPin
 }
 
 func (me *ProtoIdentify) String() string {
-	return me.ProtoHeader.String() + fmt.Sprintf(" bkdr:%x digest:%s",
+	return me.ProtoHeader.String() + fmt.Sprintf(" bkdr:%x digest:%s time:%d pin:%v",
 		me.bkdr,
-		hex.EncodeToString(me.digest))
+		hex.EncodeToString(me.digest),
+		me.time,
+		me.pin)
+}
+
+func (me *ProtoIdentify) exts() []ProtoExt {
+	exts := []ProtoExt{}
+
+	if 0 != me.time {
+		exts = append(exts, extUint32(extTime, uint32(me.time)))
+	}
+
+	if me.pin {
+		exts = append(exts, extByte(extPin, 1))
+	}
+
+	return exts
+}
+
+func (me *ProtoIdentify) ext(tag byte, value []byte) error {
+	switch tag {
+	case extTime:
+		if len(value) < SizeofInt32 {
+			return ErrTooShortBuffer
+		}
+
+		me.time = Time32(Ntohl(value))
+	case extPin:
+		me.pin = len(value) > 0 && 0 != value[0]
+	}
+
+	return nil
 }
 
 const sizeofProtoIdentifyFixed = 2 * SizeofInt32
@@ -30,7 +65,7 @@ func (me *ProtoIdentify) FixedSize() int {
 }
 
 func (me *ProtoIdentify) Size() int {
-	return me.ProtoHeader.Size() + me.FixedSize() + len(me.digest)
+	return me.ProtoHeader.Size() + me.FixedSize() + len(me.digest) + extSize(me.exts())
 }
 
 func (me *ProtoIdentify) ToBinary(bin []byte) error {
@@ -48,7 +83,7 @@ func (me *ProtoIdentify) ToBinary(bin []byte) error {
 	// dynamic ==> binary
 	copy(bin[me.FixedSize():], me.digest)
 
-	return nil
+	return extToBinary(bin[me.FixedSize()+len(me.digest):], me.exts())
 }
 
 func (me *ProtoIdentify) FromBinary(bin []byte) error {
@@ -70,5 +105,6 @@ func (me *ProtoIdentify) FromBinary(bin []byte) error {
 	// binary ==> dyanmic
 	me.digest, offset = GetBytes(bin, offset, ndigest)
 
-	return nil
+	// binary ==> ext
+	return extFromBinary(bin[offset:], me.ext)
 }
@@ -0,0 +1,144 @@
+package udfs
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	. "asdf"
+)
+
+// listing of udfs.db, tool ==> local broker
+// the broker hold udfs.db, so the live listing is by broker
+//
+// paged, the broker NOT hold the whole listing @memory
+// request with the cursor of the last page(nil is the first)
+// reply: ncursor(4) + cursor of the next page(nil is the end) + text of page
+const (
+	listDb  = "db"
+	listPin = "pin"
+	listGc  = "gc"
+)
+
+var ErrBadList = errors.New("bad list name")
+
+// list request
+type ProtoList struct {
+	ProtoHeader
+
+	days uint32 // just gc
+	live uint32 // just gc
+	// nname uint32 // just protocol, not delete this line
+
+	name string
+
+	// ext
+	// ncursor uint32 // just protocol, not delete this line
+	cursor []byte // the next page, nil is the first
+}
+
+func (me *ProtoList) String() string {
+	return me.ProtoHeader.String() + fmt.Sprintf(" name:%s days:%d live:%d cursor:%s",
+		me.name,
+		me.days,
+		me.live,
+		hex.EncodeToString(me.cursor))
+}
+
+// write a page of the listing from cursor(nil is the first)
+// return the cursor of next page, nil is the end
+func (me *ProtoList) page(w io.Writer, tx MetaTx, cursor []byte) ([]byte, error) {
+	switch me.name {
+	case listDb:
+		return dbInspectTx(w, tx, cursor)
+	case listPin:
+		return pinListTx(w, tx, cursor)
+	case listGc:
+		return gcReportTx(w, tx, int(me.days), int(me.live), cursor)
+	default:
+		return nil, ErrBadList
+	}
+}
+
+// write the whole listing of tx
+func (me *ProtoList) list(w io.Writer, tx MetaTx) error {
+	var cursor []byte
+
+	for {
+		next, err := me.page(w, tx, cursor)
+		if nil != err || nil == next {
+			return err
+		}
+
+		cursor = next
+	}
+}
+
+const sizeofProtoListFixed = 3 * SizeofInt32
+
+func (me *ProtoList) FixedSize() int {
+	return sizeofProtoListFixed
+}
+
+func (me *ProtoList) Size() int {
+	return me.ProtoHeader.Size() + me.FixedSize() + len(me.name) + SizeofInt32 + len(me.cursor)
+}
+
+func (me *ProtoList) ToBinary(bin []byte) error {
+	hdr := &me.ProtoHeader
+	err := hdr.ToBinary(bin[0:])
+	if nil != err {
+		return err
+	}
+	bin = bin[hdr.Size():]
+
+	// fixed ==> binary
+	Htonl(bin[0:], me.days)
+	Htonl(bin[4:], me.live)
+	Htonl(bin[8:], uint32(len(me.name)))
+
+	// dynamic ==> binary
+	copy(bin[me.FixedSize():], me.name)
+
+	// ext ==> binary
+	bin = bin[me.FixedSize()+len(me.name):]
+	Htonl(bin, uint32(len(me.cursor)))
+	copy(bin[SizeofInt32:], me.cursor)
+
+	return nil
+}
+
+func (me *ProtoList) FromBinary(bin []byte) error {
+	hdr := &me.ProtoHeader
+	err := hdr.FromBinary(bin[0:])
+	if nil != err {
+		return err
+	}
+	bin = bin[hdr.Size():]
+
+	if len(bin) < me.FixedSize() {
+		return ErrTooShortBuffer
+	}
+
+	// binary ==> fixed
+	me.days = Ntohl(bin[0:])
+	me.live = Ntohl(bin[4:])
+	nname := int(Ntohl(bin[8:]))
+	if 0 == nname {
+		return ErrEmpty
+	}
+
+	// binary ==> dyanmic
+	name, offset := GetBytes(bin, me.FixedSize(), nname)
+	me.name = string(name)
+
+	// binary ==> ext, the old tool NOT send it
+	if len(bin) >= offset+SizeofInt32 {
+		if ncursor := int(Ntohl(bin[offset:])); ncursor > 0 {
+			me.cursor, _ = GetBytes(bin, offset+SizeofInt32, ncursor)
+		}
+	}
+
+	return nil
+}
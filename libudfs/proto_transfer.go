@@ -19,13 +19,42 @@ type ProtoTransfer struct {
 
 	digest  []byte
 	content []byte
+
+	// ext, FileAttr.size is len(content)
+	FileAttr
 }
 
 func (me *ProtoTransfer) String() string {
-	return me.ProtoHeader.String() + fmt.Sprintf(" bkdr:%x digest:%s content:%s",
+	return me.ProtoHeader.String() + fmt.Sprintf(" bkdr:%x digest:%s content:%s %s",
 		me.bkdr,
 		hex.EncodeToString(me.digest),
-		hex.EncodeToString(me.content))
+		hex.EncodeToString(me.content),
+		me.FileAttr.String())
+}
+
+func (me *ProtoTransfer) exts() []ProtoExt {
+	exts := []ProtoExt{}
+
+	if nsDefault != me.ns {
+		exts = append(exts, extByte(extNamespace, me.ns))
+	}
+
+	return append(exts, me.FileAttr.exts()...)
+}
+
+func (me *ProtoTransfer) ext(tag byte, value []byte) error {
+	switch tag {
+	case extNamespace:
+		if len(value) < SizeofByte {
+			return ErrTooShortBuffer
+		}
+
+		me.ns = value[0]
+	default:
+		return me.FileAttr.ext(tag, value)
+	}
+
+	return nil
 }
 
 const sizeofProtoTransferFixed = 4 * SizeofInt32
@@ -35,7 +64,7 @@ func (me *ProtoTransfer) FixedSize() int {
 }
 
 func (me *ProtoTransfer) Size() int {
-	return me.ProtoHeader.Size() + me.FixedSize() + len(me.digest) + len(me.content)
+	return me.ProtoHeader.Size() + me.FixedSize() + len(me.digest) + len(me.content) + extSize(me.exts())
 }
 
 func (me *ProtoTransfer) ToBinary(bin []byte) error {
@@ -59,7 +88,8 @@ func (me *ProtoTransfer) ToBinary(bin []byte) error {
 	begin += len(me.digest)
 	copy(bin[begin:], me.content)
 
-	return nil
+	begin += len(me.content)
+	return extToBinary(bin[begin:], me.exts())
 }
 
 func (me *ProtoTransfer) FromBinary(bin []byte) error {
@@ -86,6 +116,8 @@ func (me *ProtoTransfer) FromBinary(bin []byte) error {
 	// binary ==> dynamic
 	me.digest, offset = GetBytes(bin, offset, ndigest)
 	me.content, offset = GetBytes(bin, offset, ncontent)
+	me.size = uint64(ncontent)
 
-	return nil
+	// binary ==> ext
+	return extFromBinary(bin[offset:], me.ext)
 }
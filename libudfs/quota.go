@@ -0,0 +1,195 @@
+package udfs
+
+import (
+	"encoding/binary"
+	"errors"
+
+	. "asdf"
+)
+
+// storage quota of namespace/client
+//
+// usage of each broker(its db), NOT aggregated @cluster
+// so the quota is of each leader, the files of its bkdrs(and of its followings)
+// bucket quota: 'n' + ns(1) or 'c' + client(4) ==> bytes(8) + objects(8)
+// updated with the entry @the same tx(dbPutTx/dbDelTx)
+//
+// the client is the publisher pushed it(auth enabled), saved @entry(extClient)
+// new file over quota is rejected by the node taking the publisher's push(time is 0), with errnoQuota
+// the replica push(leader to followers, xrepl) is NOT checked, the copies of a file NOT diverge
+
+const (
+	quotaTagNamespace byte = 'n'
+	quotaTagClient    byte = 'c'
+
+	sizeofQuotaUsage = 2 * 8
+)
+
+var ErrQuota = errors.New("quota exceeded")
+
+var dbQuotaBucket = []byte("quota")
+
+// 0 is unlimited
+type QuotaConf struct {
+	Bytes   uint64 `json:"bytes"`
+	Objects uint64 `json:"objects"`
+}
+
+func (me *QuotaConf) over(usage *QuotaUsage, size uint64) bool {
+	return (0 != me.Bytes && usage.Bytes+size > me.Bytes) ||
+		(0 != me.Objects && usage.Objects+1 > me.Objects)
+}
+
+type QuotaUsage struct {
+	Bytes   uint64
+	Objects uint64
+}
+
+func dbQuotaNsKey(ns byte) []byte {
+	return []byte{quotaTagNamespace, ns}
+}
+
+func dbQuotaClientKey(client uint32) []byte {
+	key := make([]byte, SizeofByte+SizeofInt32)
+
+	key[0] = quotaTagClient
+	Htonl(key[SizeofByte:], client)
+
+	return key
+}
+
+// keys of the entry's usage
+func dbQuotaKeys(attr *FileAttr) [][]byte {
+	keys := [][]byte{dbQuotaNsKey(attr.ns)}
+
+	if 0 != attr.client {
+		keys = append(keys, dbQuotaClientKey(attr.client))
+	}
+
+	return keys
+}
+
+func dbQuotaGetTx(tx MetaTx, key []byte) *QuotaUsage {
+	usage := &QuotaUsage{}
+
+	if v := tx.Get(dbQuotaBucket, key); len(v) >= sizeofQuotaUsage {
+		usage.Bytes = binary.BigEndian.Uint64(v)
+		usage.Objects = binary.BigEndian.Uint64(v[8:])
+	}
+
+	return usage
+}
+
+// add(sign > 0) or sub(sign < 0) the entry to usage
+func dbQuotaAddTx(tx MetaTx, attr *FileAttr, sign int) error {
+	for _, key := range dbQuotaKeys(attr) {
+		usage := dbQuotaGetTx(tx, key)
+
+		if sign > 0 {
+			usage.Bytes += attr.size
+			usage.Objects++
+		} else {
+			// NOT less than 0
+			if usage.Bytes > attr.size {
+				usage.Bytes -= attr.size
+			} else {
+				usage.Bytes = 0
+			}
+
+			if usage.Objects > 0 {
+				usage.Objects--
+			}
+		}
+
+		v := make([]byte, sizeofQuotaUsage)
+		binary.BigEndian.PutUint64(v, usage.Bytes)
+		binary.BigEndian.PutUint64(v[8:], usage.Objects)
+
+		if err := tx.Put(dbQuotaBucket, key, v); nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// check quota of the new file
+func quotaCheck(attr *FileAttr) error {
+	var err error
+
+	db.View(func(tx MetaTx) error {
+		err = quotaCheckTx(tx, attr)
+
+		return nil
+	})
+
+	return err
+}
+
+func quotaCheckTx(tx MetaTx, attr *FileAttr) error {
+	var err error
+
+	check := func(quota *QuotaConf, key []byte, name string) {
+		if nil != err || (0 == quota.Bytes && 0 == quota.Objects) {
+			return
+		}
+
+		if usage := dbQuotaGetTx(tx, key); quota.over(usage, attr.size) {
+			Log.Info("quota: %s usage bytes:%d objects:%d, push size:%d rejected",
+				name, usage.Bytes, usage.Objects, attr.size)
+
+			err = ErrQuota
+		}
+	}
+
+	if ns := conf.namespace(attr.ns); nil != ns {
+		check(&ns.Quota, dbQuotaNsKey(attr.ns), "namespace:"+ns.Name)
+	}
+
+	if 0 != attr.client {
+		if client := conf.Auth.find(attr.client); nil != client {
+			check(&client.Quota, dbQuotaClientKey(attr.client), "client:"+client.Name)
+		}
+	}
+
+	return err
+}
+
+// put the new entry if NOT over quota, checked @the same tx
+func dbPutQuota(entry *DbEntry) error {
+	return db.Update(func(tx MetaTx) error {
+		if v := tx.Get(dbBucket(entry.bkdr), entry.digest[:]); nil == v {
+			if err := quotaCheckTx(tx, &entry.FileAttr); nil != err {
+				return err
+			}
+		}
+
+		return dbPutTx(tx, entry)
+	})
+}
+
+// count usage of the existed entries, as migration
+func dbMigrateQuota(tx MetaTx, bucket []byte) error {
+	entries := []*DbEntry{}
+
+	err := tx.ForEach(bucket, nil, func(k, v []byte) error {
+		entry := &DbEntry{}
+		if nil == entry.FromBinary(v) {
+			entries = append(entries, entry)
+		}
+
+		return nil
+	})
+	if nil != err {
+		return err
+	}
+
+	// NOT put @ForEach
+	for _, entry := range entries {
+		if err = dbQuotaAddTx(tx, &entry.FileAttr, 1); nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
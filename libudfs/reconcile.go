@@ -0,0 +1,304 @@
+package udfs
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	. "asdf"
+)
+
+// reconcile bolt index and files
+//
+// missing: entry without file, crash/manual cleanup
+// orphan: file without entry, crash/fgc failed after entry deleted
+// tmp: tmp file left by crashed save
+//
+// run at broker startup(before listen), and on SIGUSR1
+
+const (
+	reconcileDelete = "delete"
+	reconcileKeep   = "keep"
+	reconcileRepair = "repair" // missing: pull from replicas
+	reconcileAdopt  = "adopt"  // orphan: add entry if digest is good
+
+	// tmp file older than it is dead
+	reconcileTmpAge = 3600 // second
+
+	// orphan file newer than it maybe pushing(saved, entry NOT yet), NOT orphan
+	reconcileOrphanAge = 600 // second
+)
+
+var ErrReconciling = errors.New("reconciling")
+
+type ReconcileConf struct {
+	SkipStartup bool   `json:"skipstartup"`
+	Missing     string `json:"missing"` // repair(default), delete or keep
+	Orphan      string `json:"orphan"`  // delete(default), adopt or keep
+}
+
+func (me *ReconcileConf) setDefault() {
+	if Empty == me.Missing {
+		me.Missing = reconcileRepair
+	}
+
+	if Empty == me.Orphan {
+		me.Orphan = reconcileDelete
+	}
+}
+
+func (me *ReconcileConf) check() int {
+	// check before setDefault, Empty is default
+	switch me.Missing {
+	case Empty, reconcileRepair, reconcileDelete, reconcileKeep:
+	default:
+		Log.Error("bad reconcile missing policy:%s", me.Missing)
+
+		return StdErrError
+	}
+
+	switch me.Orphan {
+	case Empty, reconcileAdopt, reconcileDelete, reconcileKeep:
+	default:
+		Log.Error("bad reconcile orphan policy:%s", me.Orphan)
+
+		return StdErrError
+	}
+
+	return 0
+}
+
+type ReconcileStat struct {
+	Entries  int
+	Missing  int
+	Repaired int
+	Files    int
+	Orphans  int
+	Adopted  int
+	Deleted  int // entries and files
+	Tmps     int
+}
+
+var reconciling = make(chan struct{}, 1)
+
+// broker api
+// reconcile once, ErrReconciling if running
+func BrokerReconcile() (*ReconcileStat, error) {
+	select {
+	case reconciling <- struct{}{}:
+		defer func() { <-reconciling }()
+	default:
+		return nil, ErrReconciling
+	}
+
+	stat := &ReconcileStat{}
+
+	if err := reconcileEntries(stat); nil != err {
+		return stat, err
+	}
+
+	// just local store has files to walk
+	if blobIsLocal() {
+		reconcileFiles(stat)
+	}
+
+	Log.Info("reconcile entries:%d missing:%d repaired:%d files:%d orphans:%d adopted:%d deleted:%d tmps:%d",
+		stat.Entries,
+		stat.Missing,
+		stat.Repaired,
+		stat.Files,
+		stat.Orphans,
+		stat.Adopted,
+		stat.Deleted,
+		stat.Tmps)
+
+	return stat, nil
+}
+
+// by batch of dbScanBatch, NOT load all entries
+func reconcileEntries(stat *ReconcileStat) error {
+	var checkpoint []byte
+
+	all := func(entry *DbEntry) bool {
+		return true
+	}
+
+	for {
+		entries, next := dbScanEntries(checkpoint, all)
+
+		reconcileMissing(stat, entries)
+
+		if string(next) == string(checkpoint) {
+			break
+		}
+		checkpoint = next
+	}
+
+	return nil
+}
+
+func reconcileMissing(stat *ReconcileStat, entries []*DbEntry) {
+	for _, entry := range entries {
+		stat.Entries++
+
+		file := entry.File()
+		if blobStore.Exist(file) {
+			continue
+		}
+		stat.Missing++
+
+		Log.Info("reconcile missing %s", entry.String())
+
+		switch conf.Reconcile.Missing {
+		case reconcileRepair:
+			if err := ep.pullGroup(entry.bkdr, entry.digest[:]); nil == err {
+				stat.Repaired++
+			} else {
+				Log.Error("reconcile repair %s error:%v", entry.String(), err)
+			}
+		case reconcileDelete:
+			if nil == dbDel(entry.bkdr, entry.digest[:]) {
+				stat.Deleted++
+			}
+		}
+	}
+}
+
+func reconcileFiles(stat *ReconcileStat) {
+	orphans := []UdfsFile{}
+	now := time.Now()
+
+	for idir, dir := range dbConf.dirs() {
+		if Empty == dir {
+			continue
+		}
+
+		filepath.Walk(dir, func(filename string, info os.FileInfo, err error) error {
+			if nil != err || info.IsDir() {
+				return nil
+			}
+
+			file := UdfsFile{
+				name: FileName(filename),
+				idir: idir,
+			}
+
+			if isTmpFile(filename) {
+				if now.Sub(info.ModTime()) > reconcileTmpAge*time.Second {
+					stat.Tmps++
+
+					file.Delete()
+				}
+
+				return nil
+			}
+
+			bkdr, digest, ok := parseFile(filename)
+			if !ok {
+				return nil
+			}
+			stat.Files++
+
+			if now.Sub(info.ModTime()) < reconcileOrphanAge*time.Second {
+				// maybe pushing
+				return nil
+			}
+
+			// the file of entry @other dir is a stray copy
+			// the trashed is NOT orphan
+			entry, _ := dbGet(bkdr, digest)
+			if (nil == entry || int(entry.idir) != idir) && !dbTrashed(bkdr, digest, idir) {
+				orphans = append(orphans, file)
+			}
+
+			return nil
+		})
+	}
+
+	// check again after walk
+	// the saving file(pushing) has entry now
+	for _, file := range orphans {
+		bkdr, digest, _ := parseFile(file.String())
+
+		entry, _ := dbGet(bkdr, digest)
+		if nil != entry && int(entry.idir) == file.idir {
+			continue
+		} else if dbTrashed(bkdr, digest, file.idir) {
+			continue
+		}
+		stat.Orphans++
+
+		Log.Info("reconcile orphan %s", file.String())
+
+		switch conf.Reconcile.Orphan {
+		case reconcileAdopt:
+			if nil == entry && 0 == dbTomb(bkdr, digest) && reconcileAdoptFile(file, bkdr, digest) {
+				stat.Adopted++
+			} else if nil == file.Delete() {
+				stat.Deleted++
+			}
+		case reconcileDelete:
+			if nil == file.Delete() {
+				stat.Deleted++
+			}
+		}
+	}
+}
+
+// add entry of orphan file @its dir
+func reconcileAdoptFile(file UdfsFile, bkdr Bkdr, digest []byte) bool {
+	content, err := file.Load()
+	if nil != err {
+		return false
+	}
+
+	if !bytes.Equal(DeftDigester.Digest(content), digest) {
+		Log.Error("reconcile adopt %s bad digest", file.String())
+
+		return false
+	}
+
+	info, err := os.Stat(file.String())
+	if nil != err {
+		return false
+	}
+
+	entry := &DbEntry{
+		FileAttr: FileAttr{
+			ns:   nsDefault,
+			size: uint64(len(content)),
+		},
+		time: Time32(info.ModTime().Unix()),
+		bkdr: bkdr,
+		idir: byte(file.idir),
+	}
+	copy(entry.digest[:], digest)
+
+	return nil == dbPut(entry)
+}
+
+// reconcile on SIGUSR1
+func reconcileOnSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+
+	for range ch {
+		go BrokerReconcile()
+	}
+}
+
+func initReconcile(role Role) {
+	if role != roleBroker {
+		return
+	}
+
+	if !conf.Reconcile.SkipStartup {
+		BrokerReconcile()
+	}
+
+	go reconcileOnSignal()
+}
@@ -30,3 +30,13 @@ func (me Role) String() string {
 		return Unknow
 	}
 }
+
+func roleFromString(s string) Role {
+	for role, name := range udfsRoles {
+		if s == name {
+			return Role(role)
+		}
+	}
+
+	return roleEnd
+}
@@ -0,0 +1,119 @@
+package udfs
+
+import (
+	. "asdf"
+)
+
+// tombstone of deleted file
+//
+// bucket tomb: bkdr(4) + digest ==> time(4) + expire(4)
+// time: the del time, expire: the tombstone expire
+//
+// written by EndPoint.del(so replicated with del), removed when expired
+// push/touch/pull(repair) older than time is dropped
+// the same second: the push after del wins, it removes the tombstone
+
+const (
+	deftTombLive = 7 * 86400 // 3600*24*7
+
+	sizeofTomb    = 2 * SizeofInt32
+	sizeofTombKey = SizeofInt32 + DigestSize
+)
+
+var dbTombBucket = []byte("tomb")
+
+func dbTombKey(bkdr Bkdr, digest []byte) []byte {
+	key := make([]byte, sizeofTombKey)
+
+	Htonl(key, uint32(bkdr))
+	copy(key[SizeofInt32:], digest)
+
+	return key
+}
+
+// del time of tombstone, 0 if not exist
+func dbTombTx(tx MetaTx, bkdr Bkdr, digest []byte) Time32 {
+	v := tx.Get(dbTombBucket, dbTombKey(bkdr, digest))
+	if len(v) < sizeofTomb {
+		return 0
+	}
+
+	return Time32(Ntohl(v))
+}
+
+func dbTomb(bkdr Bkdr, digest []byte) Time32 {
+	var time Time32
+
+	db.View(func(tx MetaTx) error {
+		time = dbTombTx(tx, newbkdr(bkdr, digest), digest)
+
+		return nil
+	})
+
+	return time
+}
+
+// the file(time) is deleted, NOT write it
+func dbTombed(bkdr Bkdr, digest []byte, time Time32) bool {
+	tomb := dbTomb(bkdr, digest)
+
+	return 0 != tomb && tomb > newtime32(time)
+}
+
+// delete(or trash) entry, and keep the tombstone
+// force: NOT trash, and forget the trashed before
+func dbTombPutTx(tx MetaTx, bkdr Bkdr, digest []byte, time Time32, force bool) error {
+	if old := dbTombTx(tx, bkdr, digest); old > time {
+		time = old
+	}
+
+	v := make([]byte, sizeofTomb)
+	Htonl(v, uint32(time))
+	Htonl(v[SizeofInt32:], uint32(NowTime32()+conf.TombLive))
+
+	if err := tx.Put(dbTombBucket, dbTombKey(bkdr, digest), v); nil != err {
+		return err
+	}
+
+	if force {
+		if err := dbTrashDelTx(tx, bkdr, digest); nil != err {
+			return err
+		}
+	} else if trashEnabled() {
+		return dbTrashPutTx(tx, bkdr, digest)
+	}
+
+	return dbDelTx(tx, bkdr, digest)
+}
+
+func dbTombPut(bkdr Bkdr, digest []byte, time Time32, force bool) error {
+	bkdr = newbkdr(bkdr, digest)
+
+	return db.Update(func(tx MetaTx) error {
+		return dbTombPutTx(tx, bkdr, digest, newtime32(time), force)
+	})
+}
+
+// remove expired tombstones, return count of removed
+func dbTombPrune() int {
+	now := NowTime32()
+	keys := [][]byte{}
+
+	db.ForEach(dbTombBucket, nil, func(k, v []byte) error {
+		if len(v) < sizeofTomb || Time32(Ntohl(v[SizeofInt32:])) < now {
+			keys = append(keys, append([]byte{}, k...))
+		}
+
+		return nil
+	})
+
+	db.Update(func(tx MetaTx) error {
+		for _, k := range keys {
+			tx.Delete(dbTombBucket, k)
+		}
+
+		return nil
+	})
+
+	return len(keys)
+}
@@ -0,0 +1,46 @@
+package udfs
+
+import (
+	"testing"
+
+	. "asdf"
+)
+
+func TestTombRepush(t *testing.T) {
+	db = newMemStore()
+
+	bkdr := Bkdr(1)
+	digest := make([]byte, DigestSize)
+	digest[0] = 1
+
+	entry := &DbEntry{
+		bkdr: bkdr,
+		time: NowTime32(),
+	}
+	copy(entry.digest[:], digest)
+
+	if err := dbPut(entry); nil != err {
+		t.Fatalf("put error:%v", err)
+	}
+
+	// del, then push again @the same second
+	if err := dbTombPut(bkdr, digest, entry.time, true); nil != err {
+		t.Fatalf("tomb put error:%v", err)
+	} else if _, err := dbGet(bkdr, digest); nil == err {
+		t.Fatalf("entry NOT deleted")
+	}
+
+	if !dbTombed(bkdr, digest, entry.time-1) {
+		t.Fatalf("older push NOT dropped")
+	} else if dbTombed(bkdr, digest, entry.time) {
+		t.Fatalf("same second push dropped")
+	}
+
+	if err := dbPut(entry); nil != err {
+		t.Fatalf("put again error:%v", err)
+	} else if _, err := dbGet(bkdr, digest); nil != err {
+		t.Fatalf("get after push again error:%v", err)
+	} else if tomb := dbTomb(bkdr, digest); 0 != tomb {
+		t.Fatalf("tomb:%d NOT removed", tomb)
+	}
+}
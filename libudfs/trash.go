@@ -0,0 +1,327 @@
+package udfs
+
+import (
+	. "asdf"
+)
+
+// trash, soft delete
+//
+// Conf.TrashGrace > 0, the deleted(del/gc) entry is moved to trash, its file is kept
+// restored by cmdUndel in TrashGrace, else purged(file deleted) by gc
+// the trashed before is purged by gc, even if TrashGrace is 0 now
+// del with flagForce(DeleteOption.Force) is NOT trashed
+//
+// bucket trash: bkdr(4) + digest ==> purge(4) + entry
+// bucket trashpurge: purge(4) + bkdr(4) + digest ==> empty, ordered by purge time
+//
+// the file @removed/failed dir is NOT moved, maybe lost before purged
+
+const sizeofTrashPurgeKey = 2*SizeofInt32 + DigestSize
+
+var (
+	dbTrashBucket      = []byte("trash")
+	dbTrashPurgeBucket = []byte("trashpurge")
+)
+
+func trashEnabled() bool {
+	return conf.TrashGrace > 0
+}
+
+func dbTrashKey(bkdr Bkdr, digest []byte) []byte {
+	key := make([]byte, SizeofInt32+DigestSize)
+
+	Htonl(key, uint32(bkdr))
+	copy(key[SizeofInt32:], digest)
+
+	return key
+}
+
+func dbTrashPurgeKey(purge Time32, key []byte) []byte {
+	pkey := make([]byte, sizeofTrashPurgeKey)
+
+	Htonl(pkey, uint32(purge))
+	copy(pkey[SizeofInt32:], key)
+
+	return pkey
+}
+
+// the trashed entry and its purge time, nil if not exist
+func dbTrashTx(tx MetaTx, bkdr Bkdr, digest []byte) (*DbEntry, Time32) {
+	v := tx.Get(dbTrashBucket, dbTrashKey(bkdr, digest))
+	if len(v) < SizeofInt32 {
+		return nil, 0
+	}
+
+	entry := &DbEntry{}
+	if err := entry.FromBinary(v[SizeofInt32:]); nil != err {
+		return nil, 0
+	}
+
+	return entry, Time32(Ntohl(v))
+}
+
+// the trashed entry, nil if not exist
+func dbTrashGet(bkdr Bkdr, digest []byte) *DbEntry {
+	var entry *DbEntry
+
+	db.View(func(tx MetaTx) error {
+		entry, _ = dbTrashTx(tx, newbkdr(bkdr, digest), digest)
+
+		return nil
+	})
+
+	return entry
+}
+
+// the file @idir is trashed
+func dbTrashed(bkdr Bkdr, digest []byte, idir int) bool {
+	trashed := false
+
+	db.View(func(tx MetaTx) error {
+		entry, _ := dbTrashTx(tx, bkdr, digest)
+		trashed = nil != entry && int(entry.idir) == idir
+
+		return nil
+	})
+
+	return trashed
+}
+
+// update the trashed entry, keep its purge time
+func dbTrashSetTx(tx MetaTx, bkdr Bkdr, digest []byte, entry *DbEntry, purge Time32) error {
+	bin, err := ToBinary(entry)
+	if nil != err {
+		return err
+	}
+
+	value := make([]byte, SizeofInt32+len(bin))
+	Htonl(value, uint32(purge))
+	copy(value[SizeofInt32:], bin)
+
+	return tx.Put(dbTrashBucket, dbTrashKey(bkdr, digest), value)
+}
+
+func dbTrashDelTx(tx MetaTx, bkdr Bkdr, digest []byte) error {
+	key := dbTrashKey(bkdr, digest)
+
+	if _, purge := dbTrashTx(tx, bkdr, digest); 0 != purge {
+		if err := tx.Delete(dbTrashPurgeBucket, dbTrashPurgeKey(purge, key)); nil != err {
+			return err
+		}
+	}
+
+	return tx.Delete(dbTrashBucket, key)
+}
+
+// move the entry to trash, if exist
+// the trashed before is replaced, its file is the same or orphan
+func dbTrashPutTx(tx MetaTx, bkdr Bkdr, digest []byte) error {
+	v := tx.Get(dbBucket(bkdr), digest)
+	if nil == v {
+		return nil
+	}
+
+	if err := dbTrashDelTx(tx, bkdr, digest); nil != err {
+		return err
+	}
+
+	key := dbTrashKey(bkdr, digest)
+	purge := NowTime32() + conf.TrashGrace
+
+	value := make([]byte, SizeofInt32+len(v))
+	Htonl(value, uint32(purge))
+	copy(value[SizeofInt32:], v)
+
+	if err := tx.Put(dbTrashBucket, key, value); nil != err {
+		return err
+	} else if err = tx.Put(dbTrashPurgeBucket, dbTrashPurgeKey(purge, key), []byte{}); nil != err {
+		return err
+	}
+
+	return dbDelTx(tx, bkdr, digest)
+}
+
+// remove entry @local, the file is trashed or deleted
+func dbRemove(entry *DbEntry) error {
+	if !trashEnabled() {
+		blobStore.Delete(entry.File())
+
+		return dbDel(entry.bkdr, entry.digest[:])
+	}
+
+	return db.Update(func(tx MetaTx) error {
+		return dbTrashPutTx(tx, entry.bkdr, entry.digest[:])
+	})
+}
+
+// restore the trashed entry, touched now
+// nil if the entry exist
+func dbTrashRestore(bkdr Bkdr, digest []byte) (*DbEntry, error) {
+	bkdr = newbkdr(bkdr, digest)
+
+	var entry *DbEntry
+	var err error
+
+	db.View(func(tx MetaTx) error {
+		if v := tx.Get(dbBucket(bkdr), digest); nil == v {
+			entry, _ = dbTrashTx(tx, bkdr, digest)
+		}
+
+		return nil
+	})
+	if nil == entry {
+		if dbExist(bkdr, digest) {
+			return nil, nil
+		}
+
+		return nil, ErrNoExist
+	}
+
+	if !dbConf.has(entry.idir) || !blobStore.Exist(entry.File()) {
+		// file lost, forget it
+		db.Update(func(tx MetaTx) error {
+			return dbTrashDelTx(tx, bkdr, digest)
+		})
+
+		return nil, ErrNoExist
+	}
+	entry.time = NowTime32()
+
+	err = db.Update(func(tx MetaTx) error {
+		if err := dbTrashDelTx(tx, bkdr, digest); nil != err {
+			return err
+		} else if err = tx.Delete(dbTombBucket, dbTombKey(bkdr, digest)); nil != err {
+			return err
+		}
+
+		return dbPutTx(tx, entry)
+	})
+	if nil != err {
+		return nil, err
+	}
+
+	blobStore.Touch(entry.File(), entry.time)
+
+	return entry, nil
+}
+
+// purge the trashed before now, at most dbGcBatch
+// return count of purged
+func dbTrashPurge(now Time32) int {
+	keys := [][]byte{}
+
+	db.ForEach(dbTrashPurgeBucket, nil, func(k, v []byte) error {
+		if len(k) == sizeofTrashPurgeKey && Time32(Ntohl(k)) >= now {
+			return ErrStop
+		} else if len(keys) >= dbGcBatch {
+			return ErrStop
+		}
+
+		keys = append(keys, append([]byte{}, k...))
+
+		return nil
+	})
+
+	dbTrashPurgeKeys(keys)
+
+	return len(keys)
+}
+
+// purge the trashed @dir, the earliest first, until need bytes freed
+// for disk pressure, NOT wait TrashGrace
+// return count and bytes of purged
+func dbTrashPurgeDir(idir int, need uint64) (int, uint64) {
+	count, freed := 0, uint64(0)
+	var begin []byte
+
+	for freed < need {
+		keys := [][]byte{}
+		size := uint64(0)
+		from := begin
+
+		db.View(func(tx MetaTx) error {
+			return tx.ForEach(dbTrashPurgeBucket, from, func(k, v []byte) error {
+				if nil != from && string(k) == string(from) {
+					// done @last batch
+					return nil
+				} else if len(keys) >= dbGcBatch || freed+size >= need {
+					return ErrStop
+				}
+				begin = append([]byte{}, k...)
+
+				if len(k) != sizeofTrashPurgeKey {
+					return nil
+				}
+
+				entry, _ := dbTrashTx(tx, Bkdr(Ntohl(k[SizeofInt32:])), k[2*SizeofInt32:])
+				if nil != entry && int(entry.idir) == idir {
+					keys = append(keys, append([]byte{}, k...))
+					size += entry.size
+				}
+
+				return nil
+			})
+		})
+
+		if 0 == len(keys) {
+			break
+		}
+
+		count += dbTrashPurgeKeys(keys)
+		freed += size
+	}
+
+	return count, freed
+}
+
+// purge the trashed of keys(@trashpurge), return count of purged
+func dbTrashPurgeKeys(keys [][]byte) int {
+	files := []UdfsFile{}
+
+	err := db.Update(func(tx MetaTx) error {
+		files = files[:0]
+
+		for _, k := range keys {
+			tx.Delete(dbTrashPurgeBucket, k)
+
+			if len(k) != sizeofTrashPurgeKey {
+				continue
+			}
+
+			bkdr := Bkdr(Ntohl(k[SizeofInt32:]))
+			digest := k[2*SizeofInt32:]
+
+			entry, purge := dbTrashTx(tx, bkdr, digest)
+			if nil == entry || purge != Time32(Ntohl(k)) {
+				// stale key
+				continue
+			}
+			tx.Delete(dbTrashBucket, dbTrashKey(bkdr, digest))
+
+			live := &DbEntry{}
+			if v := tx.Get(dbBucket(bkdr), digest); nil != v && nil == live.FromBinary(v) &&
+				live.idir == entry.idir && live.loc == entry.loc {
+				// pushed again, the same file
+				continue
+			}
+
+			if dbConf.has(entry.idir) {
+				files = append(files, entry.File())
+			}
+		}
+
+		return nil
+	})
+	if nil != err {
+		Log.Error("trash purge error:%v", err)
+
+		return 0
+	}
+
+	// after commit
+	for _, file := range files {
+		blobStore.Delete(file)
+	}
+
+	return len(files)
+}
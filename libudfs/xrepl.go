@@ -0,0 +1,443 @@
+package udfs
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	. "asdf"
+)
+
+// cross-cluster replication
+//
+// 1. leader log push/touch/del/undel/pin to xlog bucket
+// 2. xrepl send xlog to remote cluster, asynchronous
+// 3. checkpoint saved @xrepl bucket, resume from it after restart
+// 4. remote resolve conflict by DbEntry time, the newer win
+//
+// request from remote has flagRemote, NOT log it again
+// the remote node(not leader) forward it to its leader
+// push/touch/del to followers when leader down are NOT logged
+//
+// the xlog failed xreplRetry times is moved to xrepldead bucket(seq ==> xlog), NOT block the later
+// just if the remote is reachable(a later xlog sent), NOT when the remote is down
+
+const (
+	xreplBatch    = 128
+	xreplInterval = 1 * time.Second
+	xreplRetry    = 10
+)
+
+var (
+	xlogBucket      = []byte("xlog")
+	xreplBucket     = []byte("xrepl")
+	xreplDeadBucket = []byte("xrepldead")
+	xreplCheckpoint = []byte("checkpoint")
+)
+
+var xrepl *Xrepl
+
+// remote cluster config
+type RemoteConf struct {
+	Nodes  []*NodeConf `json:"nodes"`
+	Port   int         `json:"port"`   // default port of remote nodes
+	Client *ClientConf `json:"client"` // credential of remote cluster, maybe nil
+}
+
+func (me *RemoteConf) setDefault() {
+	if 0 == me.Port {
+		me.Port = UDFS_PORT
+	}
+
+	for _, node := range me.Nodes {
+		node.setDefault(me.Port)
+	}
+}
+
+type XlogEntry struct {
+	cmd    ProtoCmd
+	ns     byte
+	time   Time32
+	bkdr   Bkdr
+	digest [DigestSize]byte
+	pin    bool // pin or unpin, just cmdPin
+}
+
+func (me *XlogEntry) String() string {
+	return fmt.Sprintf("cmd:%s time:%v bkdr:%x digest:%s ns:%d pin:%v",
+		me.cmd.String(),
+		me.time.Unix(),
+		me.bkdr,
+		hex.EncodeToString(me.digest[:]),
+		me.ns,
+		me.pin)
+}
+
+// the old xlog(before pin) has no pin byte
+const (
+	sizeofXlogEntryOld = 2*SizeofByte + 2*SizeofInt32 + DigestSize
+	sizeofXlogEntry    = sizeofXlogEntryOld + SizeofByte
+)
+
+func (me *XlogEntry) Size() int {
+	return sizeofXlogEntry
+}
+
+func (me *XlogEntry) ToBinary(bin []byte) error {
+	if len(bin) < me.Size() {
+		return ErrTooShortBuffer
+	}
+
+	bin[0] = byte(me.cmd)
+	bin[1] = me.ns
+	Htonl(bin[2:], uint32(me.time))
+	Htonl(bin[6:], uint32(me.bkdr))
+
+	copy(bin[10:], me.digest[:])
+
+	bin[sizeofXlogEntryOld] = 0
+	if me.pin {
+		bin[sizeofXlogEntryOld] = 1
+	}
+
+	return nil
+}
+
+func (me *XlogEntry) FromBinary(bin []byte) error {
+	if len(bin) < sizeofXlogEntryOld {
+		return ErrTooShortBuffer
+	}
+
+	me.cmd = ProtoCmd(bin[0])
+	me.ns = bin[1]
+	me.time = Time32(Ntohl(bin[2:]))
+	me.bkdr = Bkdr(Ntohl(bin[6:]))
+
+	copy(me.digest[:], bin[10:])
+
+	me.pin = len(bin) > sizeofXlogEntryOld && 0 != bin[sizeofXlogEntryOld]
+
+	return nil
+}
+
+func newXrepl(remote *RemoteConf) *Xrepl {
+	xrepl := &Xrepl{
+		nodes: make([]*Node, len(remote.Nodes)),
+		fails: map[uint64]int{},
+	}
+
+	for i, node := range remote.Nodes {
+		xrepl.nodes[i] = &Node{
+			alive:  true,
+			addr:   NewTcpAddr(node.Port, node.Host),
+			client: remote.Client,
+			flag:   flagRemote,
+		}
+	}
+
+	return xrepl
+}
+
+type Xrepl struct {
+	nodes []*Node
+	fails map[uint64]int // seq ==> failed count, @sync only
+}
+
+// log request handled by self
+func (me *Xrepl) log(hdr *ProtoHeader, msg IBinary) {
+	if hdr.flag.Has(flagRemote) {
+		return
+	}
+
+	entry := &XlogEntry{
+		cmd: hdr.cmd,
+	}
+
+	switch hdr.cmd {
+	case cmdPush:
+		obj := msg.(*ProtoTransfer)
+
+		entry.bkdr = obj.bkdr
+		copy(entry.digest[:], obj.digest)
+	case cmdTouch, cmdDel, cmdUndel, cmdPin:
+		obj := msg.(*ProtoIdentify)
+
+		entry.bkdr = obj.bkdr
+		entry.time = obj.time
+		entry.pin = obj.pin
+		copy(entry.digest[:], obj.digest)
+	default:
+		return
+	}
+
+	if ep.self() != ep.leader(entry.bkdr) {
+		return
+	}
+
+	if cmdDel == entry.cmd {
+		// the del time of tombstone, as @followers
+		entry.time = newtime32(dbTomb(entry.bkdr, entry.digest[:]))
+	} else if e, err := dbGet(entry.bkdr, entry.digest[:]); nil == err {
+		// push/touch/undel/pin, the time/ns of db
+		entry.time = e.time
+		entry.ns = e.ns
+	} else {
+		return
+	}
+
+	if err := xlogAppend(entry); nil != err {
+		Log.Error("xlog append %s error:%v", entry.String(), err)
+	}
+}
+
+func xlogAppend(entry *XlogEntry) error {
+	bin, err := ToBinary(entry)
+	if nil != err {
+		return err
+	}
+
+	return db.Update(func(tx MetaTx) error {
+		seq, err := tx.NextSequence(xlogBucket)
+		if nil != err {
+			return err
+		}
+
+		var key [8]byte
+		binary.BigEndian.PutUint64(key[:], seq)
+
+		return tx.Put(xlogBucket, key[:], bin)
+	})
+}
+
+// load xlog after checkpoint
+func (me *Xrepl) load(checkpoint uint64, count int) ([]uint64, []*XlogEntry, error) {
+	seqs := []uint64{}
+	entries := []*XlogEntry{}
+
+	var begin [8]byte
+	binary.BigEndian.PutUint64(begin[:], checkpoint+1)
+
+	err := db.ForEach(xlogBucket, begin[:], func(k, v []byte) error {
+		if len(entries) >= count {
+			return ErrStop
+		}
+
+		entry := &XlogEntry{}
+		if err := entry.FromBinary(v); nil != err {
+			return err
+		}
+
+		seqs = append(seqs, binary.BigEndian.Uint64(k))
+		entries = append(entries, entry)
+
+		return nil
+	})
+
+	return seqs, entries, err
+}
+
+func (me *Xrepl) checkpoint() uint64 {
+	v, err := db.Get(xreplBucket, xreplCheckpoint)
+	if nil != err || len(v) != 8 {
+		return 0
+	}
+
+	return binary.BigEndian.Uint64(v)
+}
+
+// save checkpoint, and drop the sent xlog
+func (me *Xrepl) save(checkpoint uint64) error {
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], checkpoint)
+
+	return db.Update(func(tx MetaTx) error {
+		if err := tx.Put(xreplBucket, xreplCheckpoint, v[:]); nil != err {
+			return err
+		}
+
+		sent := [][]byte{}
+		tx.ForEach(xlogBucket, nil, func(k, v []byte) error {
+			if binary.BigEndian.Uint64(k) > checkpoint {
+				return ErrStop
+			}
+
+			sent = append(sent, append([]byte{}, k...))
+
+			return nil
+		})
+
+		// NOT delete @ForEach
+		for _, k := range sent {
+			if err := tx.Delete(xlogBucket, k); nil != err {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// move the failed xlog to dead bucket
+func (me *Xrepl) dead(seq uint64, entry *XlogEntry) error {
+	bin, err := ToBinary(entry)
+	if nil != err {
+		return err
+	}
+
+	var k [8]byte
+	binary.BigEndian.PutUint64(k[:], seq)
+
+	Log.Error("xrepl %s dead, failed %d times", entry.String(), me.fails[seq])
+
+	return db.Update(func(tx MetaTx) error {
+		return tx.Put(xreplDeadBucket, k[:], bin)
+	})
+}
+
+// try remote leader first, then others
+func (me *Xrepl) call(bkdr Bkdr, handle func(node *Node) error) error {
+	var err error
+
+	// any remote node, it forward to its leader, see EndPoint.remoteForward
+	count := len(me.nodes)
+	ifirst := int(bkdr % Bkdr(count))
+
+	for i := 0; i < count; i++ {
+		err = handle(me.nodes[(ifirst+i)%count])
+		if nil == err {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func (me *Xrepl) send(entry *XlogEntry) error {
+	bkdr := entry.bkdr
+	digest := entry.digest[:]
+
+	switch entry.cmd {
+	case cmdPush:
+		e, err := dbGet(bkdr, digest)
+		if nil != err {
+			// deleted after push, the del is in xlog too
+			return nil
+		}
+
+		content, err := blobStore.Load(e.File())
+		if nil != err {
+			Log.Error("xrepl %s skipped, load error:%v", entry.String(), err)
+
+			return nil
+		}
+
+		return me.call(bkdr, func(node *Node) error {
+			return node.push(bkdr, entry.time, digest, content, &e.FileAttr)
+		})
+	case cmdTouch:
+		return me.call(bkdr, func(node *Node) error {
+			return node.touch(bkdr, digest, entry.time)
+		})
+	case cmdDel:
+		return me.call(bkdr, func(node *Node) error {
+			_, err := node.del(bkdr, digest, entry.time, false)
+
+			return err
+		})
+	case cmdUndel:
+		return me.call(bkdr, func(node *Node) error {
+			return node.undel(bkdr, digest)
+		})
+	case cmdPin:
+		return me.call(bkdr, func(node *Node) error {
+			return node.pin(bkdr, digest, entry.pin)
+		})
+	default:
+		return nil
+	}
+}
+
+// return count of done(sent or dead) xlog
+func (me *Xrepl) sync() (int, error) {
+	checkpoint := me.checkpoint()
+
+	seqs, entries, err := me.load(checkpoint, xreplBatch)
+	if nil != err || 0 == len(entries) {
+		return 0, err
+	}
+
+	done := 0
+	// failed over xreplRetry, dead if a later sent
+	deads := []int{}
+
+	for i, entry := range entries {
+		if err = me.send(entry); nil == err {
+			for _, j := range deads {
+				if e := me.dead(seqs[j], entries[j]); nil != e {
+					err = e
+
+					break
+				}
+			}
+			if nil != err {
+				break
+			}
+
+			deads = deads[:0]
+			done = i + 1
+
+			continue
+		}
+
+		Log.Info("xrepl %s error:%v", entry.String(), err)
+
+		if me.fails[seqs[i]]++; me.fails[seqs[i]] < xreplRetry {
+			break
+		}
+
+		deads = append(deads, i)
+	}
+
+	if done > 0 {
+		for seq := range me.fails {
+			if seq <= seqs[done-1] {
+				delete(me.fails, seq)
+			}
+		}
+
+		if e := me.save(seqs[done-1]); nil != e {
+			return 0, e
+		}
+	}
+
+	if done < len(entries) {
+		return done, err
+	}
+
+	return done, nil
+}
+
+func (me *Xrepl) run() {
+	chTick := time.Tick(xreplInterval)
+
+	for {
+		select {
+		case <-chTick:
+			for {
+				count, err := me.sync()
+				if nil != err || count < xreplBatch {
+					break
+				}
+			}
+		}
+	}
+}
+
+func initXrepl(role Role) {
+	remote := conf.Remote
+
+	if role == roleBroker && nil != remote && len(remote.Nodes) > 0 {
+		xrepl = newXrepl(remote)
+	}
+}
@@ -0,0 +1,255 @@
+package udfs
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+
+	. "asdf"
+)
+
+// loopback two-cluster: the local broker(db @memory) and a fake remote cluster @127.0.0.1
+const (
+	xreplTestPort     = 16091
+	xreplTestDownPort = 16092 // nothing listen
+)
+
+type xreplRemote struct {
+	listener *TcpListener
+	bad      byte // digest[0] of the failed forever
+
+	lock  sync.Mutex
+	recvs []*XlogEntry
+}
+
+func newXreplRemote(t *testing.T, bad byte) *xreplRemote {
+	listener, err := ListenTcp(xreplTestPort, "127.0.0.1")
+	if nil != err {
+		t.Fatalf("listen error:%v", err)
+	}
+
+	remote := &xreplRemote{
+		listener: listener,
+		bad:      bad,
+	}
+	go remote.serve()
+
+	return remote
+}
+
+func (me *xreplRemote) serve() {
+	for {
+		conn, err := me.listener.AcceptTCP()
+		if nil != err {
+			return
+		}
+
+		go me.handle(NewTcpStream(conn))
+	}
+}
+
+func (me *xreplRemote) handle(stream *TcpStream) {
+	defer stream.Close()
+
+	hdr, msg, err := protoRead(stream, true)
+	if nil != err {
+		return
+	}
+
+	obj, ok := msg.(*ProtoIdentify)
+	if !ok || !hdr.flag.Has(flagRemote) {
+		replyError(stream, hdr.cmd, errnoError, "bad request")
+
+		return
+	} else if obj.digest[0] == me.bad {
+		replyError(stream, hdr.cmd, errnoError, "bad digest")
+
+		return
+	}
+
+	entry := &XlogEntry{
+		cmd:  hdr.cmd,
+		bkdr: obj.bkdr,
+	}
+	copy(entry.digest[:], obj.digest)
+
+	me.lock.Lock()
+	me.recvs = append(me.recvs, entry)
+	me.lock.Unlock()
+
+	replyOk(stream, hdr.cmd)
+}
+
+func (me *xreplRemote) received() []byte {
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	digests := []byte{}
+	for _, entry := range me.recvs {
+		digests = append(digests, entry.digest[0])
+	}
+
+	return digests
+}
+
+func (me *xreplRemote) close() {
+	me.listener.Close()
+}
+
+func xreplTestInit(port int) *Xrepl {
+	db = newMemStore()
+	ep = &EndPoint{
+		role: roleBroker,
+	}
+
+	return newXrepl(&RemoteConf{
+		Nodes: []*NodeConf{{Host: "127.0.0.1", Port: port}},
+	})
+}
+
+// xlog touch/del of digest[0] = ids[i]
+func xreplTestAppend(t *testing.T, ids ...byte) {
+	for i, id := range ids {
+		entry := &XlogEntry{
+			cmd:  cmdTouch,
+			time: NowTime32(),
+			bkdr: Bkdr(1 + id),
+		}
+		if 1 == i%2 {
+			entry.cmd = cmdDel
+		}
+		entry.digest[0] = id
+
+		if err := xlogAppend(entry); nil != err {
+			t.Fatalf("xlog append error:%v", err)
+		}
+	}
+}
+
+func xreplTestDeads(t *testing.T) []byte {
+	ids := []byte{}
+
+	db.ForEach(xreplDeadBucket, nil, func(k, v []byte) error {
+		entry := &XlogEntry{}
+		if err := entry.FromBinary(v); nil != err {
+			t.Fatalf("dead xlog seq:%d error:%v", binary.BigEndian.Uint64(k), err)
+		}
+		ids = append(ids, entry.digest[0])
+
+		return nil
+	})
+
+	return ids
+}
+
+func TestXreplLoopback(t *testing.T) {
+	remote := newXreplRemote(t, 0xff)
+	defer remote.close()
+
+	xr := xreplTestInit(xreplTestPort)
+	xreplTestAppend(t, 1, 2, 3)
+
+	if count, err := xr.sync(); nil != err || 3 != count {
+		t.Fatalf("sync count:%d error:%v", count, err)
+	}
+
+	if recvs := remote.received(); string(recvs) != string([]byte{1, 2, 3}) {
+		t.Fatalf("remote received:%v", recvs)
+	}
+
+	if checkpoint := xr.checkpoint(); 3 != checkpoint {
+		t.Fatalf("checkpoint:%d", checkpoint)
+	}
+
+	if seqs, _, _ := xr.load(0, xreplBatch); 0 != len(seqs) {
+		t.Fatalf("xlog NOT dropped:%v", seqs)
+	}
+}
+
+func TestXreplDead(t *testing.T) {
+	remote := newXreplRemote(t, 2)
+	defer remote.close()
+
+	xr := xreplTestInit(xreplTestPort)
+	xreplTestAppend(t, 1, 2, 3)
+
+	// blocked until retry limit
+	for i := 1; i < xreplRetry; i++ {
+		if _, err := xr.sync(); nil == err {
+			t.Fatalf("sync %d NOT failed", i)
+		}
+
+		if recvs := remote.received(); string(recvs) != string([]byte{1}) {
+			t.Fatalf("sync %d remote received:%v", i, recvs)
+		}
+	}
+
+	if count, err := xr.sync(); nil != err || 2 != count {
+		t.Fatalf("sync count:%d error:%v", count, err)
+	}
+
+	if recvs := remote.received(); string(recvs) != string([]byte{1, 3}) {
+		t.Fatalf("remote received:%v", recvs)
+	}
+
+	if deads := xreplTestDeads(t); string(deads) != string([]byte{2}) {
+		t.Fatalf("deads:%v", deads)
+	}
+
+	if checkpoint := xr.checkpoint(); 3 != checkpoint {
+		t.Fatalf("checkpoint:%d", checkpoint)
+	}
+}
+
+func TestXreplRemoteDown(t *testing.T) {
+	xr := xreplTestInit(xreplTestDownPort)
+	xreplTestAppend(t, 1, 2)
+
+	for i := 0; i <= xreplRetry; i++ {
+		if count, err := xr.sync(); nil == err || 0 != count {
+			t.Fatalf("sync %d count:%d error:%v", i, count, err)
+		}
+	}
+
+	if deads := xreplTestDeads(t); 0 != len(deads) {
+		t.Fatalf("dead when remote down:%v", deads)
+	}
+
+	if checkpoint := xr.checkpoint(); 0 != checkpoint {
+		t.Fatalf("checkpoint:%d", checkpoint)
+	}
+}
+
+// the remote non-leader forward the xlog to its leader(the fake remote)
+func TestXreplForward(t *testing.T) {
+	remote := newXreplRemote(t, 0xff)
+	defer remote.close()
+
+	thisNodeID = 0
+	ep = &EndPoint{
+		nodes: []*Node{
+			{alive: true, addr: NewTcpAddr(xreplTestDownPort, "127.0.0.1")},
+			{alive: true, addr: NewTcpAddr(xreplTestPort, "127.0.0.1")},
+		},
+		leaders: 2,
+		role:    roleBroker,
+	}
+
+	for _, id := range []byte{1, 2} {
+		msg := &ProtoIdentify{
+			ProtoHeader: NewProtoHeader(cmdTouch, flagRemote),
+			bkdr:        Bkdr(id),
+			digest:      make([]byte, DigestSize),
+		}
+		msg.digest[0] = id
+
+		// bkdr 1: leader is the fake remote, bkdr 2: leader is self
+		if _, forwarded := ep.remoteForward(msg); forwarded != (1 == id) {
+			t.Fatalf("bkdr:%d forwarded:%v", id, forwarded)
+		}
+	}
+
+	if recvs := remote.received(); string(recvs) != string([]byte{1}) {
+		t.Fatalf("leader received:%v", recvs)
+	}
+}
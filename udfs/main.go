@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+
+	. "udfs/libudfs"
+)
+
+var errGcReportUsage = fmt.Errorf("usage: udfs gc report [days] [live] [dbfile], 0 <= days <= %d, live >= 0", GcReportMaxDays)
+
+type command struct {
+	usage  string
+	handle func(args []string) error
+}
+
+var commands = map[string]*command{
+	"db inspect": {
+		usage: "udfs db inspect [dbfile]",
+		handle: func(args []string) error {
+			filename := ""
+			if len(args) > 0 {
+				filename = args[0]
+			}
+
+			return DbInspect(os.Stdout, filename)
+		},
+	},
+	"pin list": {
+		usage: "udfs pin list [dbfile]",
+		handle: func(args []string) error {
+			filename := ""
+			if len(args) > 0 {
+				filename = args[0]
+			}
+
+			return PinList(os.Stdout, filename)
+		},
+	},
+	"gc report": {
+		usage: "udfs gc report [days] [live] [dbfile]",
+		handle: func(args []string) error {
+			days, live, filename := 0, 0, ""
+
+			var err error
+			if len(args) > 0 {
+				if days, err = strconv.Atoi(args[0]); nil != err || days < 0 || days > GcReportMaxDays {
+					return errGcReportUsage
+				}
+			}
+
+			if len(args) > 1 {
+				if live, err = strconv.Atoi(args[1]); nil != err || live < 0 || uint64(live) > math.MaxUint32 {
+					return errGcReportUsage
+				}
+			}
+
+			if len(args) > 2 {
+				filename = args[2]
+			}
+
+			return GcReport(os.Stdout, filename, days, live)
+		},
+	},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage:")
+
+	for _, cmd := range commands {
+		fmt.Fprintln(os.Stderr, "\t"+cmd.usage)
+	}
+
+	os.Exit(1)
+}
+
+func main() {
+	if len(os.Args) < 3 {
+		usage()
+	}
+
+	cmd, ok := commands[os.Args[1]+" "+os.Args[2]]
+	if !ok {
+		usage()
+	}
+
+	if err := cmd.handle(os.Args[3:]); nil != err {
+		fmt.Fprintln(os.Stderr, err)
+
+		os.Exit(1)
+	}
+}